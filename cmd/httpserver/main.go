@@ -1,46 +1,145 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/CheeseFizz/httpfromtcp/internal/headers"
+	"github.com/CheeseFizz/httpfromtcp/internal/middleware"
 	"github.com/CheeseFizz/httpfromtcp/internal/request"
+	"github.com/CheeseFizz/httpfromtcp/internal/response"
+	"github.com/CheeseFizz/httpfromtcp/internal/router"
 	"github.com/CheeseFizz/httpfromtcp/internal/server"
 )
 
 const port = 42069
 
-func MyHandler(w io.Writer, req *request.Request) *server.HandlerError {
-	herr := server.HandlerError{}
-	switch req.RequestLine.RequestTarget {
-	case "/yourproblem":
-		herr.StatusCode = 400
-		herr.Message = "Your problem is not my problem\n"
+const shutdownTimeout = 10 * time.Second
 
-	case "/myproblem":
-		herr.StatusCode = 500
-		herr.Message = "Woopsie, my bad\n"
+// proxyHandler streams the upstream httpbin response back as a chunked body,
+// with trailers describing the full body.
+func proxyHandler(w *response.Writer, req *request.Request) *server.HandlerError {
+	target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin")
+	resp, err := http.Get("https://httpbin.org" + target)
+	if err != nil {
+		return &server.HandlerError{
+			StatusCode: response.StatusBadGateway,
+			Message:    fmt.Sprintf("upstream request failed: %v\n", err),
+		}
+	}
+	defer resp.Body.Close()
+
+	h := response.GetDefaultHeaders(0)
+	h.Del("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Content-SHA256, X-Content-Length")
+	if ctype := resp.Header.Get("Content-Type"); ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+
+	err = w.WriteStatusLine(response.StatusCode(resp.StatusCode))
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	hash := sha256.New()
+	total := 0
+	buf := make([]byte, 1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			hash.Write(buf[:n])
+			total += n
+			_, werr := w.WriteChunkedBody(buf[:n])
+			if werr != nil {
+				log.Println(werr)
+				return nil
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Println(err)
+			break
+		}
+	}
+
+	_, err = w.WriteChunkedBodyDone()
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Content-SHA256", hex.EncodeToString(hash.Sum(nil)))
+	trailers.Set("X-Content-Length", strconv.Itoa(total))
+	err = w.WriteTrailers(trailers)
+	if err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+func yourProblemHandler(w *response.Writer, req *request.Request) *server.HandlerError {
+	return &server.HandlerError{
+		StatusCode: response.StatusBadRequest,
+		Message:    "Your problem is not my problem\n",
+	}
+}
 
-	default:
-		herr.StatusCode = 200
-		w.Write([]byte("All good, frfr\n"))
+func myProblemHandler(w *response.Writer, req *request.Request) *server.HandlerError {
+	return &server.HandlerError{
+		StatusCode: response.StatusInternalServerError,
+		Message:    "Woopsie, my bad\n",
 	}
-	return &herr
+}
+
+func allGoodHandler(w *response.Writer, req *request.Request) *server.HandlerError {
+	w.Write([]byte("All good, frfr\n"))
+	return nil
 }
 
 func main() {
-	server, err := server.Serve(port, MyHandler)
+	rt := router.New()
+	rt.Use(middleware.Recover)
+	rt.Handle("/yourproblem", yourProblemHandler)
+	rt.Handle("/myproblem", myProblemHandler)
+	rt.Handle("GET /httpbin/*path", proxyHandler)
+	rt.Handle("/*path", allGoodHandler)
+
+	server, err := server.Serve(port, rt.Serve)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	cut, err := server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Server stopped, %d connections cut: %v", cut, err)
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
@@ -79,7 +79,7 @@ func main() {
 		)
 
 		fmt.Println("Headers:")
-		for key, val := range request.Headers {
+		for key, val := range request.Headers.All() {
 			fmt.Printf("- %s: %s\n", key, val)
 		}
 
@@ -0,0 +1,154 @@
+package cookie
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SameSite int
+
+const (
+	// no SameSite attribute; the browser picks its default
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+// Cookie is a cookie received in a Cookie header, where only Name and Value are
+// set, or one to send in a Set-Cookie header.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time
+	// MaxAge is in seconds. Zero leaves the attribute out and a negative value
+	// sends Max-Age=0, deleting the cookie.
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+}
+
+// Parse parses the value of a Cookie request header. Pairs that are not valid
+// per RFC 6265 are skipped rather than failing the whole header, since one bad
+// cookie from another app on the domain should not lock the user out.
+func Parse(header string) []*Cookie {
+	cookies := []*Cookie{}
+	// a comma never appears in a valid pair, and separates repeated headers
+	for _, pair := range strings.FieldsFunc(header, func(r rune) bool { return r == ';' || r == ',' }) {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !validName(name) {
+			continue
+		}
+		value, ok := parseValue(value)
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// Valid checks the cookie can be sent in a Set-Cookie header.
+func (c *Cookie) Valid() error {
+	if !validName(c.Name) {
+		return fmt.Errorf("invalid cookie name '%s'", c.Name)
+	}
+	if _, ok := parseValue(c.Value); !ok {
+		return fmt.Errorf("invalid value for cookie '%s'", c.Name)
+	}
+	if strings.ContainsFunc(c.Path, func(r rune) bool { return r < 0x20 || r == 0x7f || r == ';' }) {
+		return fmt.Errorf("invalid path for cookie '%s'", c.Name)
+	}
+	if !validDomain(c.Domain) {
+		return fmt.Errorf("invalid domain for cookie '%s'", c.Name)
+	}
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return fmt.Errorf("cookie '%s' has SameSite=None without Secure", c.Name)
+	}
+	return nil
+}
+
+// String returns the Set-Cookie field value for the cookie. Call Valid first;
+// String does not check the cookie.
+func (c *Cookie) String() string {
+	var sb strings.Builder
+	sb.WriteString(c.Name)
+	sb.WriteString("=")
+	sb.WriteString(c.Value)
+
+	if len(c.Path) > 0 {
+		sb.WriteString("; Path=" + c.Path)
+	}
+	if len(c.Domain) > 0 {
+		sb.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		sb.WriteString("; Expires=" + c.Expires.UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT"))
+	}
+	if c.MaxAge > 0 {
+		sb.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		sb.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		sb.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		sb.WriteString("; HttpOnly")
+	}
+	switch c.SameSite {
+	case SameSiteLax:
+		sb.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		sb.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		sb.WriteString("; SameSite=None")
+	}
+	return sb.String()
+}
+
+// validName reports whether name is an RFC 9110 token.
+func validName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("\"(),/:;<=>?@[\\]{}", c) != -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// parseValue checks value against the cookie-value grammar of RFC 6265,
+// removing the optional surrounding quotes.
+func parseValue(value string) (string, bool) {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+func validDomain(domain string) bool {
+	domain = strings.TrimPrefix(domain, ".")
+	for i := 0; i < len(domain); i++ {
+		c := domain[i]
+		if !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z') && !('0' <= c && c <= '9') && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
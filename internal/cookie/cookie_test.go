@@ -0,0 +1,70 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Several cookies
+	cookies := Parse("session=abc123; theme=dark;lang=\"en\"")
+	require.Equal(t, 3, len(cookies))
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.Equal(t, "abc123", cookies[0].Value)
+	assert.Equal(t, "theme", cookies[1].Name)
+	assert.Equal(t, "dark", cookies[1].Value)
+	assert.Equal(t, "en", cookies[2].Value)
+
+	// Test: Invalid pairs are skipped
+	cookies = Parse("good=1; bad name=2; noequals; b@d=3; spaced=a b; ok=")
+	require.Equal(t, 2, len(cookies))
+	assert.Equal(t, "good", cookies[0].Name)
+	assert.Equal(t, "ok", cookies[1].Name)
+	assert.Equal(t, "", cookies[1].Value)
+
+	// Test: Repeated Cookie headers joined with commas
+	cookies = Parse("a=1,b=2")
+	require.Equal(t, 2, len(cookies))
+
+	// Test: Empty header
+	assert.Equal(t, 0, len(Parse("")))
+}
+
+func TestString(t *testing.T) {
+	// Test: All attributes
+	c := &Cookie{
+		Name:     "session",
+		Value:    "abc123",
+		Path:     "/",
+		Domain:   ".example.com",
+		Expires:  time.Date(2030, time.January, 2, 15, 4, 5, 0, time.UTC),
+		MaxAge:   3600,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: SameSiteStrict,
+	}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Wed, 02 Jan 2030 15:04:05 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=Strict", c.String())
+
+	// Test: Deleting a cookie
+	c = &Cookie{Name: "session", MaxAge: -1}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "session=; Max-Age=0", c.String())
+
+	// Test: Invalid cookies
+	invalid := []*Cookie{
+		{Name: "", Value: "x"},
+		{Name: "bad name", Value: "x"},
+		{Name: "a", Value: "semi;colon"},
+		{Name: "a", Value: "line\r\nbreak"},
+		{Name: "a", Value: "x", Path: "/;evil"},
+		{Name: "a", Value: "x", Domain: "exa mple.com"},
+		{Name: "a", Value: "x", SameSite: SameSiteNone},
+	}
+	for _, c := range invalid {
+		assert.Error(t, c.Valid(), c.Name+"="+c.Value)
+	}
+}
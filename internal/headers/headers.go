@@ -2,32 +2,128 @@ package headers
 
 import (
 	"fmt"
-	"regexp"
+	"iter"
 	"strings"
 	"unicode"
 )
 
-type Headers map[string]string
+// Field is a single field line. Name keeps the casing it was received or set
+// with; lookups ignore case.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Headers is an ordered list of field lines. Repeated fields are kept as
+// separate lines, so ones that cannot be comma-joined, like Set-Cookie,
+// survive intact.
+type Headers struct {
+	fields []Field
+}
 
-func (h Headers) Get(key string) (value string, ok bool) {
-	lower_key := strings.ToLower(key)
-	val, ok := h[lower_key]
-	if !ok {
+func (h *Headers) Get(key string) (value string, ok bool) {
+	values := h.Values(key)
+	if len(values) == 0 {
 		return "", false
 	}
 
-	return val, true
+	return strings.Join(values, ","), true
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
-	// Note: this function will always return done=false for the first run of valid data, even if there are headers
-	// 		The only time this won't be the case is when data starts with CRLF
+// Values returns the value of every field line named key, in order.
+func (h *Headers) Values(key string) []string {
+	values := []string{}
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, key) {
+			values = append(values, f.Value)
+		}
+	}
+	return values
+}
+
+// Add appends a field line, keeping any existing ones with the same name.
+func (h *Headers) Add(key, value string) {
+	h.fields = append(h.fields, Field{Name: key, Value: value})
+}
+
+// Set replaces every field line named key with a single one, in the position
+// of the first.
+func (h *Headers) Set(key, value string) {
+	for i, f := range h.fields {
+		if strings.EqualFold(f.Name, key) {
+			h.fields[i] = Field{Name: key, Value: value}
+			h.del(key, i+1)
+			return
+		}
+	}
+	h.Add(key, value)
+}
+
+func (h *Headers) Del(key string) {
+	h.del(key, 0)
+}
+
+// del removes the field lines named key from index start on.
+func (h *Headers) del(key string, start int) {
+	kept := h.fields[:start]
+	for _, f := range h.fields[start:] {
+		if !strings.EqualFold(f.Name, key) {
+			kept = append(kept, f)
+		}
+	}
+	h.fields = kept
+}
+
+// Len returns the number of field lines.
+func (h *Headers) Len() int {
+	return len(h.fields)
+}
 
-	// match invalid field name characters
-	namefilter, err := regexp.Compile(`[^A-Za-z0-9\x60!#$%\^&*_\-+\.\|~]`)
-	if err != nil {
-		return 0, false, fmt.Errorf("regexp.Compile failed")
+// All iterates over the field lines in order, with names as received or set.
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for _, f := range h.fields {
+			if !yield(f.Name, f.Value) {
+				return
+			}
+		}
+	}
+}
+
+func (h *Headers) Clone() *Headers {
+	clone := NewHeaders()
+	clone.fields = append(clone.fields, h.fields...)
+	return clone
+}
+
+// Canonicalize rewrites every field name to its canonical form, see
+// CanonicalKey.
+func (h *Headers) Canonicalize() {
+	for i := range h.fields {
+		h.fields[i].Name = CanonicalKey(h.fields[i].Name)
 	}
+}
+
+// CanonicalKey returns key with the first letter and every letter after a
+// hyphen upper case and the rest lower case, e.g. "content-type" becomes
+// "Content-Type".
+func CanonicalKey(key string) string {
+	b := []byte(key)
+	upper := true
+	for i, c := range b {
+		if upper && 'a' <= c && c <= 'z' {
+			b[i] = c - 'a' + 'A'
+		} else if !upper && 'A' <= c && c <= 'Z' {
+			b[i] = c - 'A' + 'a'
+		}
+		upper = c == '-'
+	}
+	return string(b)
+}
+
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
+	// Note: this function will always return done=false for the first run of valid data, even if there are headers
+	// 		The only time this won't be the case is when data starts with CRLF
 
 	strdata := string(data)
 	lencrlf := len([]byte("\r\n"))
@@ -47,8 +143,15 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 
 	// range over complete header info (all but last item in slice)
 	for _, line := range lines[:len(lines)-1] {
+		// end of headers; leave the CRLF for the next call so it reports done
 		if len(line) == 0 {
-			continue
+			break
+		}
+
+		// obs-fold continues the previous field; taking it as a field of its own
+		// would disagree with a parser that unfolds it (RFC 9112 §5.2)
+		if line[0] == ' ' || line[0] == '\t' {
+			return 0, false, fmt.Errorf("bad request: %w '%s'", ErrObsFold, line)
 		}
 
 		field := strings.SplitN(line, ":", 2)
@@ -57,26 +160,20 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		}
 
 		rfname := []rune(field[0])
-		if unicode.IsSpace(rfname[len(rfname)-1]) {
+		if len(rfname) > 0 && unicode.IsSpace(rfname[len(rfname)-1]) {
 			return 0, false, fmt.Errorf("bad request: header name has trailing space '%s'", field[0])
 		}
 		fname := strings.TrimSpace(field[0])
-		fname = strings.ToLower(fname)
-		if namefilter.FindStringIndex(fname) != nil {
-			return 0, false, fmt.Errorf("bad request: invalid header name '%s'", fname)
+		if !ValidName(fname) {
+			return 0, false, fmt.Errorf("bad request: %w '%s'", ErrInvalidFieldName, fname)
 		}
 
-		fvalue := strings.TrimSpace(field[1])
-		if len(fvalue) == 0 {
-			return 0, false, fmt.Errorf("bad request: missing value for header %s", fname)
+		fvalue := strings.Trim(field[1], " \t")
+		if !ValidValue(fvalue) {
+			return 0, false, fmt.Errorf("bad request: %w for header %s", ErrInvalidFieldValue, fname)
 		}
 
-		_, ok := h[fname]
-		if ok {
-			h[fname] = fmt.Sprintf("%s,%s", h[fname], fvalue)
-		} else {
-			h[fname] = fvalue
-		}
+		h.Add(fname, fvalue)
 
 		n += len([]byte(line)) + lencrlf
 	}
@@ -84,7 +181,22 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 	return n, false, nil
 }
 
-func NewHeaders() Headers {
-	headers := make(Headers)
-	return headers
+// ContainsToken reports whether the comma-separated list in the named header
+// contains token, compared case-insensitively.
+func (h *Headers) ContainsToken(key, token string) bool {
+	for _, value := range h.Values(key) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func NewHeaders() *Headers {
+	headers := Headers{
+		fields: []Field{},
+	}
+	return &headers
 }
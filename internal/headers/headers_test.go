@@ -7,6 +7,12 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// get returns the value for key, or "" if it is not set
+func get(h *Headers, key string) string {
+	value, _ := h.Get(key)
+	return value
+}
+
 func TestHeaders(t *testing.T) {
 	// Test: Valid single header
 	headers := NewHeaders()
@@ -14,7 +20,7 @@ func TestHeaders(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", get(headers, "host"))
 	assert.Equal(t, len(data)-2, n)
 	assert.False(t, done)
 
@@ -27,33 +33,33 @@ func TestHeaders(t *testing.T) {
 
 	// Test: Valid single header with extra white space
 	headers = NewHeaders()
-	data = []byte("    Host:   localhost:42069   \r\n\r\n")
+	data = []byte("Host:   localhost:42069   \r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", get(headers, "host"))
 	assert.Equal(t, len(data)-2, n)
 	assert.False(t, done)
 
 	// Test: Valid 2 headers
 	headers = NewHeaders()
-	data = []byte("Host: localhost:42069\r\n Content-Type: application/json\r\n\r\n")
+	data = []byte("Host: localhost:42069\r\nContent-Type: application/json\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, 2, len(headers))
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, "application/json", headers["content-type"])
+	assert.Equal(t, 2, headers.Len())
+	assert.Equal(t, "localhost:42069", get(headers, "host"))
+	assert.Equal(t, "application/json", get(headers, "content-type"))
 	assert.Equal(t, len(data)-2, n)
 	assert.False(t, done)
 
 	// Test: Valid multiple values
 	headers = NewHeaders()
-	data = []byte("Host: localhost:42069\r\n Host: test.com:5555\r\n\r\n")
+	data = []byte("Host: localhost:42069\r\nHost: test.com:5555\r\n\r\n")
 	_, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069,test.com:5555", headers["host"])
+	assert.Equal(t, "localhost:42069,test.com:5555", get(headers, "host"))
 	assert.False(t, done)
 
 	// Test: Valid done
@@ -72,6 +78,18 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 
+	// Test: Folded line
+	for _, data := range []string{
+		"Host: a\r\n Transfer-Encoding: chunked\r\n\r\n",
+		"Host: a\r\n\tContent-Length: 5\r\n\r\n",
+		" Host: a\r\n\r\n",
+	} {
+		headers = NewHeaders()
+		n, _, err = headers.Parse([]byte(data))
+		require.ErrorIs(t, err, ErrObsFold)
+		assert.Equal(t, 0, n)
+	}
+
 	// Test: Invalid no colon
 	headers = NewHeaders()
 	data = []byte("Host localhost:42069\r\n\r\n")
@@ -90,3 +108,95 @@ func TestHeaders(t *testing.T) {
 	_, done, _ = headers.Parse(data)
 	assert.False(t, done)
 }
+
+func TestHeadersFields(t *testing.T) {
+	// Test: Field lines kept in order with their original casing
+	headers := NewHeaders()
+	data := []byte("X-Request-ID: 1\r\nset-cookie: a=1\r\nHOST: example.com\r\nSet-Cookie: b=2\r\n\r\n")
+	_, _, err := headers.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, 4, headers.Len())
+	names := []string{}
+	for name := range headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"X-Request-ID", "set-cookie", "HOST", "Set-Cookie"}, names)
+	assert.Equal(t, []string{"a=1", "b=2"}, headers.Values("SET-COOKIE"))
+	assert.Equal(t, "example.com", get(headers, "host"))
+
+	// Test: Set replaces every value in place of the first
+	headers.Set("Set-Cookie", "c=3")
+	assert.Equal(t, []string{"c=3"}, headers.Values("set-cookie"))
+	names = []string{}
+	for name := range headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"X-Request-ID", "Set-Cookie", "HOST"}, names)
+
+	// Test: Add keeps existing values, Del removes them all
+	headers.Add("set-cookie", "d=4")
+	assert.Equal(t, []string{"c=3", "d=4"}, headers.Values("Set-Cookie"))
+	headers.Del("SET-COOKIE")
+	assert.Empty(t, headers.Values("Set-Cookie"))
+	_, ok := headers.Get("Set-Cookie")
+	assert.False(t, ok)
+	assert.Equal(t, 2, headers.Len())
+
+	// Test: Clone is independent of the original
+	clone := headers.Clone()
+	clone.Set("Host", "other.com")
+	assert.Equal(t, "example.com", get(headers, "host"))
+	assert.Equal(t, "other.com", get(clone, "host"))
+
+	// Test: Canonicalized names
+	assert.Equal(t, "Content-Type", CanonicalKey("content-type"))
+	assert.Equal(t, "X-Request-Id", CanonicalKey("X-REQUEST-ID"))
+	assert.Equal(t, "Www-Authenticate", CanonicalKey("wWW-authenticate"))
+	headers.Canonicalize()
+	names = []string{}
+	for name := range headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"X-Request-Id", "Host"}, names)
+}
+
+func TestHeadersValidation(t *testing.T) {
+	// Test: Control characters in a value are rejected
+	for _, value := range []string{"a\x00b", "a\rb", "a\x1bb", "a\x7fb"} {
+		headers := NewHeaders()
+		_, _, err := headers.Parse([]byte("X-Test: " + value + "\r\n\r\n"))
+		require.ErrorIs(t, err, ErrInvalidFieldValue, "%q", value)
+	}
+
+	// Test: Tabs and obs-text in a value are accepted
+	headers := NewHeaders()
+	_, _, err := headers.Parse([]byte("X-Test:\tcaf\xc3\xa9\tau lait \r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "caf\xc3\xa9\tau lait", get(headers, "x-test"))
+
+	// Test: Empty values are accepted
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("X-Empty:\r\nX-Blank: \t \r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, headers.Len())
+	value, ok := headers.Get("X-Empty")
+	assert.True(t, ok)
+	assert.Equal(t, "", value)
+	assert.Equal(t, "", get(headers, "x-blank"))
+	require.NoError(t, headers.Validate())
+
+	// Test: Empty field name is rejected
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte(": value\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidFieldName)
+
+	// Test: Validate catches fields that would split a response
+	headers = NewHeaders()
+	headers.Set("Location", "/ok")
+	require.NoError(t, headers.Validate())
+	headers.Set("Location", "/ok\r\nSet-Cookie: evil=1")
+	require.ErrorIs(t, headers.Validate(), ErrInvalidFieldValue)
+	headers = NewHeaders()
+	headers.Set("X-Bad Name", "1")
+	require.ErrorIs(t, headers.Validate(), ErrInvalidFieldName)
+}
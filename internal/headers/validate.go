@@ -0,0 +1,66 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidFieldName  = errors.New("invalid field name")
+	ErrInvalidFieldValue = errors.New("invalid field value")
+	ErrObsFold           = errors.New("obsolete line folding")
+)
+
+// ValidName reports whether name is a token as defined in RFC 9110 §5.6.2.
+func ValidName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isTokenChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	switch c {
+	case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~':
+		return true
+	}
+	return false
+}
+
+// ValidValue reports whether value is a field value as defined in RFC 9110
+// §5.5: visible characters, with spaces and tabs allowed between them. Control
+// characters, CR, LF and NUL included, are rejected since they would let a
+// value end its field line early. obs-text (bytes 0x80 to 0xFF) is accepted as
+// opaque data, as the grammar allows, so UTF-8 values pass through unchanged.
+func ValidValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate returns an error for the first field line with an invalid name or
+// value, so that a response is never sent with a field that could split it.
+func (h *Headers) Validate() error {
+	for _, f := range h.fields {
+		if !ValidName(f.Name) {
+			return fmt.Errorf("%w: %q", ErrInvalidFieldName, f.Name)
+		}
+		if !ValidValue(f.Value) {
+			return fmt.Errorf("%w for %s: %q", ErrInvalidFieldValue, f.Name, f.Value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/CheeseFizz/httpfromtcp/internal/request"
+	"github.com/CheeseFizz/httpfromtcp/internal/response"
+	"github.com/CheeseFizz/httpfromtcp/internal/server"
+)
+
+// BasicAuth only lets requests through whose Basic credentials (RFC 7617) are
+// accepted by valid. Others get a 401 asking for credentials for realm.
+func BasicAuth(realm string, valid func(user, password string) bool) server.Middleware {
+	challenge := fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, strings.ReplaceAll(realm, `"`, `\"`))
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) *server.HandlerError {
+			user, password, ok := basicCredentials(req)
+			if ok && valid(user, password) {
+				return next(w, req)
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			return &server.HandlerError{
+				StatusCode: response.StatusUnauthorized,
+				Message:    "Unauthorized\n",
+			}
+		}
+	}
+}
+
+// basicCredentials returns the user and password from a Basic Authorization
+// header.
+func basicCredentials(req *request.Request) (user, password string, ok bool) {
+	auth, ok := req.Headers.Get("Authorization")
+	if !ok {
+		return "", "", false
+	}
+	scheme, encoded, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
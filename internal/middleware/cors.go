@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CheeseFizz/httpfromtcp/internal/headers"
+	"github.com/CheeseFizz/httpfromtcp/internal/request"
+	"github.com/CheeseFizz/httpfromtcp/internal/response"
+	"github.com/CheeseFizz/httpfromtcp/internal/server"
+)
+
+type CORSOptions struct {
+	// AllowedOrigins lists the origins, like "https://example.com", that may
+	// make cross-origin requests. "*" allows any origin.
+	AllowedOrigins []string
+	// AllowedMethods lists the methods allowed in preflighted requests. Empty
+	// means GET, HEAD and POST.
+	AllowedMethods []string
+	// AllowedHeaders lists the request headers allowed in preflighted requests.
+	// Empty allows whatever the preflight asks for.
+	AllowedHeaders []string
+	// AllowCredentials lets the browser send cookies and credentials.
+	AllowCredentials bool
+	// MaxAge is how long a browser may cache the result of a preflight. Zero
+	// leaves it to the browser.
+	MaxAge time.Duration
+}
+
+// CORS adds Cross-Origin Resource Sharing headers for allowed origins and
+// answers preflight requests itself with a 204. Requests from other origins
+// are passed on without CORS headers, so browsers block them.
+func CORS(options CORSOptions) server.Middleware {
+	methods := options.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{"GET", "HEAD", "POST"}
+	}
+	anyOrigin := slices.Contains(options.AllowedOrigins, "*")
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) *server.HandlerError {
+			origin, ok := req.Headers.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+			if !ok || !anyOrigin && !slices.Contains(options.AllowedOrigins, origin) {
+				return next(w, req)
+			}
+
+			h := w.Header()
+			if anyOrigin && !options.AllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if options.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			requestMethod, preflight := req.Headers.Get("Access-Control-Request-Method")
+			if req.RequestLine.Method != "OPTIONS" || !preflight {
+				return next(w, req)
+			}
+
+			if slices.Contains(methods, requestMethod) {
+				h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+				if len(options.AllowedHeaders) > 0 {
+					h.Set("Access-Control-Allow-Headers", strings.Join(options.AllowedHeaders, ", "))
+				} else if requested, ok := req.Headers.Get("Access-Control-Request-Headers"); ok {
+					h.Set("Access-Control-Allow-Headers", requested)
+				}
+				if options.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(options.MaxAge.Seconds())))
+				}
+			}
+			err := w.WriteStatusLine(response.StatusNoContent)
+			if err != nil {
+				return &server.HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+			}
+			err = w.WriteHeaders(headers.NewHeaders())
+			if err != nil {
+				return &server.HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+			}
+			return nil
+		}
+	}
+}
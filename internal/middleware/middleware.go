@@ -0,0 +1,57 @@
+// Package middleware provides server.Middleware for common cross-cutting
+// concerns. Add them with Server.Use or Router.Use.
+package middleware
+
+import (
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/CheeseFizz/httpfromtcp/internal/request"
+	"github.com/CheeseFizz/httpfromtcp/internal/response"
+	"github.com/CheeseFizz/httpfromtcp/internal/server"
+)
+
+// Logger logs the method, target, status and handler duration of each
+// request. The status is the one the response ends up with, including one
+// from a returned *server.HandlerError.
+func Logger(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		start := time.Now()
+		herr := next(w, req)
+
+		statusCode := w.Status()
+		if statusCode == 0 {
+			statusCode = response.StatusOK
+			if herr != nil {
+				statusCode = herr.StatusCode
+			}
+		}
+		log.Printf("%s %s %d %s", req.RequestLine.Method, req.RequestLine.RequestTarget, statusCode, time.Since(start))
+		return herr
+	}
+}
+
+// Recover turns a panic in the handler into a 500 response and logs it with
+// the stack. If the response was already started it cannot be replaced, so the
+// connection is closed after it instead of being reused with a response of
+// unknown length.
+func Recover(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) (herr *server.HandlerError) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			log.Printf("panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, v, debug.Stack())
+			if w.HeadersWritten() {
+				w.SetKeepAlive(false)
+			}
+			herr = &server.HandlerError{
+				StatusCode: response.StatusInternalServerError,
+				Message:    "Internal Server Error\n",
+			}
+		}()
+		return next(w, req)
+	}
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CheeseFizz/httpfromtcp/internal/headers"
+	"github.com/CheeseFizz/httpfromtcp/internal/request"
+	"github.com/CheeseFizz/httpfromtcp/internal/response"
+	"github.com/CheeseFizz/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRequest(method, target string, fields ...string) *request.Request {
+	h := headers.NewHeaders()
+	for i := 0; i+1 < len(fields); i += 2 {
+		h.Add(fields[i], fields[i+1])
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			HttpVersion:   "1.1",
+			Method:        method,
+			RequestTarget: target,
+		},
+		Headers: h,
+	}
+}
+
+func ok(w *response.Writer, req *request.Request) *server.HandlerError {
+	w.Write([]byte("ok"))
+	return nil
+}
+
+// serve runs handler the way the server does and returns the raw response
+func serve(handler server.Handler, req *request.Request) (string, *response.Writer) {
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	statusCode := response.StatusOK
+	herr := handler(w, req)
+	if herr != nil && !w.HeadersWritten() {
+		statusCode = herr.StatusCode
+		w.Reset()
+		w.Write([]byte(herr.Message))
+	}
+	w.Finish(statusCode)
+	return buf.String(), w
+}
+
+func TestRecover(t *testing.T) {
+	// Test: Panic before the response becomes a 500
+	out, w := serve(Recover(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		panic("boom")
+	}), newRequest("GET", "/"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.True(t, w.KeepAlive())
+
+	// Test: Panic mid-response closes the connection
+	out, w = serve(Recover(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.WriteStatusLine(200)
+		w.WriteHeaders(response.GetDefaultHeaders(10))
+		w.Write([]byte("half"))
+		panic("boom")
+	}), newRequest("GET", "/"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.False(t, w.KeepAlive())
+
+	// Test: No panic passes through
+	out, _ = serve(Recover(ok), newRequest("GET", "/"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nok"))
+}
+
+func TestLogger(t *testing.T) {
+	// Test: Status from a returned error is passed through
+	out, _ := serve(Logger(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		return &server.HandlerError{StatusCode: 404, Message: "nope\n"}
+	}), newRequest("GET", "/missing"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+}
+
+func TestBasicAuth(t *testing.T) {
+	handler := BasicAuth(`the "vault"`, func(user, password string) bool {
+		return user == "alice" && password == "s3:cret"
+	})(ok)
+	credentials := func(s string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(s))
+	}
+
+	// Test: Valid credentials
+	out, _ := serve(handler, newRequest("GET", "/", "Authorization", credentials("alice:s3:cret")))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nok"))
+
+	// Test: Scheme is case-insensitive
+	out, _ = serve(handler, newRequest("GET", "/", "Authorization", "basic "+base64.StdEncoding.EncodeToString([]byte("alice:s3:cret"))))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Missing, wrong or malformed credentials are challenged
+	for _, req := range []*request.Request{
+		newRequest("GET", "/"),
+		newRequest("GET", "/", "Authorization", credentials("alice:wrong")),
+		newRequest("GET", "/", "Authorization", "Basic !!!"),
+		newRequest("GET", "/", "Authorization", "Bearer token"),
+	} {
+		out, _ = serve(handler, req)
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+		assert.Contains(t, out, "WWW-Authenticate: Basic realm=\"the \\\"vault\\\"\", charset=\"UTF-8\"\r\n")
+		assert.NotContains(t, out, "ok")
+	}
+}
+
+func TestCORS(t *testing.T) {
+	handler := CORS(CORSOptions{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"GET", "PUT"},
+		MaxAge:         10 * time.Minute,
+	})(ok)
+
+	// Test: Allowed origin gets CORS headers
+	out, _ := serve(handler, newRequest("GET", "/", "Origin", "https://app.example.com"))
+	assert.Contains(t, out, "Access-Control-Allow-Origin: https://app.example.com\r\n")
+	assert.Contains(t, out, "Vary: Origin\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nok"))
+
+	// Test: Other origins and same-origin requests get none
+	out, _ = serve(handler, newRequest("GET", "/", "Origin", "https://evil.example.com"))
+	assert.NotContains(t, out, "Access-Control-")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nok"))
+	out, _ = serve(handler, newRequest("GET", "/"))
+	assert.NotContains(t, out, "Access-Control-")
+
+	// Test: Preflight answered without calling the handler
+	out, _ = serve(handler, newRequest("OPTIONS", "/",
+		"Origin", "https://app.example.com",
+		"Access-Control-Request-Method", "PUT",
+		"Access-Control-Request-Headers", "content-type"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"))
+	assert.Contains(t, out, "Access-Control-Allow-Methods: GET, PUT\r\n")
+	assert.Contains(t, out, "Access-Control-Allow-Headers: content-type\r\n")
+	assert.Contains(t, out, "Access-Control-Max-Age: 600\r\n")
+	assert.False(t, strings.HasSuffix(out, "ok"))
+
+	// Test: Preflight for a method not allowed
+	out, _ = serve(handler, newRequest("OPTIONS", "/",
+		"Origin", "https://app.example.com",
+		"Access-Control-Request-Method", "DELETE"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"))
+	assert.NotContains(t, out, "Access-Control-Allow-Methods")
+
+	// Test: Any origin with credentials echoes the origin
+	handler = CORS(CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true})(ok)
+	out, _ = serve(handler, newRequest("GET", "/", "Origin", "https://other.example.com"))
+	assert.Contains(t, out, "Access-Control-Allow-Origin: https://other.example.com\r\n")
+	assert.Contains(t, out, "Access-Control-Allow-Credentials: true\r\n")
+	handler = CORS(CORSOptions{AllowedOrigins: []string{"*"}})(ok)
+	out, _ = serve(handler, newRequest("GET", "/", "Origin", "https://other.example.com"))
+	assert.Contains(t, out, "Access-Control-Allow-Origin: *\r\n")
+}
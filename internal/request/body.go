@@ -0,0 +1,66 @@
+package request
+
+import (
+	"fmt"
+	"io"
+)
+
+// bodyReader streams a request body, decoding more of it from the connection
+// as it is read.
+type bodyReader struct {
+	reader  *Reader
+	request *Request
+	err     error
+	closed  bool
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, fmt.Errorf("read on closed body")
+	}
+	for len(b.request.pending) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		if b.request.state == requestStateDone {
+			return 0, io.EOF
+		}
+		b.err = b.reader.step(b.request)
+	}
+
+	n := copy(p, b.request.pending)
+	b.request.pending = b.request.pending[n:]
+	return n, nil
+}
+
+// Close stops the handler from reading further. Unread body bytes are still
+// on the stream and must be dropped with Reader.Discard before the next
+// request.
+func (b *bodyReader) Close() error {
+	b.closed = true
+	return nil
+}
+
+// Discard reads and drops whatever is left of req's body so the next request
+// on the stream can be parsed. It gives up with an error after max bytes,
+// since reading a large unwanted upload is worse than closing the connection;
+// a negative max means no limit.
+func (rr *Reader) Discard(req *Request, max int) error {
+	discarded := len(req.pending)
+	req.pending = nil
+	for req.state != requestStateDone {
+		if max >= 0 && discarded > max {
+			return fmt.Errorf("more than %d unread body bytes", max)
+		}
+		err := rr.step(req)
+		if err != nil {
+			return err
+		}
+		discarded += len(req.pending)
+		req.pending = nil
+	}
+	if max >= 0 && discarded > max {
+		return fmt.Errorf("more than %d unread body bytes", max)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package request
+
+import (
+	"errors"
+
+	"github.com/CheeseFizz/httpfromtcp/internal/cookie"
+)
+
+var ErrNoCookie = errors.New("named cookie not present")
+
+// Cookies returns the valid cookies sent in the Cookie header.
+func (r *Request) Cookies() []*cookie.Cookie {
+	value, ok := r.Headers.Get("Cookie")
+	if !ok {
+		return []*cookie.Cookie{}
+	}
+	return cookie.Parse(value)
+}
+
+// Cookie returns the first cookie with the given name.
+func (r *Request) Cookie(name string) (*cookie.Cookie, error) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, ErrNoCookie
+}
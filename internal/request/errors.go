@@ -0,0 +1,41 @@
+package request
+
+import "errors"
+
+// Errors returned while determining the length of a request body. Checking for
+// them with errors.Is lets the server answer with a matching status code
+// instead of guessing where the body ends, which is what request smuggling
+// relies on.
+var (
+	ErrInvalidContentLength      = errors.New("invalid content-length")
+	ErrConflictingContentLength  = errors.New("conflicting content-length values")
+	ErrContentLengthWithEncoding = errors.New("content-length sent with transfer-encoding")
+	ErrChunkedNotFinal           = errors.New("chunked is not the final transfer coding")
+	ErrEmptyTransferEncoding     = errors.New("transfer-encoding without a coding")
+	ErrUnsupportedTransferCoding = errors.New("unsupported transfer coding")
+)
+
+// Errors returned when a request is over one of its Limits. They are returned
+// as soon as the limit is crossed, before the rest of the oversize data is read.
+var (
+	ErrRequestLineTooLong = errors.New("request line too long")
+	ErrHeadersTooLarge    = errors.New("request header fields too large")
+	ErrBodyTooLarge       = errors.New("request body too large")
+)
+
+// ErrExpectationFailed is returned for an Expect header other than
+// 100-continue, the only expectation a server can meet.
+var ErrExpectationFailed = errors.New("unsupported expectation")
+
+var (
+	ErrInvalidTarget = errors.New("invalid request target")
+	ErrInvalidEscape = errors.New("invalid percent-encoding")
+)
+
+// Errors for requests that are malformed rather than over a limit.
+var (
+	ErrBadRequestLine     = errors.New("malformed request line")
+	ErrUnsupportedVersion = errors.New("unsupported http version")
+	ErrBadHeader          = errors.New("malformed header field")
+	ErrBadChunk           = errors.New("malformed chunked body")
+)
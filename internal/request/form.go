@@ -0,0 +1,134 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+)
+
+// DefaultMaxFormMemory is how much of a multipart form ParseMultipartForm keeps
+// in memory when given zero; file parts past it are spilled to temp files.
+const DefaultMaxFormMemory = 10 << 20
+
+var ErrNotMultipart = errors.New("request content-type is not multipart/form-data")
+
+// ParseForm fills PostForm from an application/x-www-form-urlencoded body and
+// Form from that plus the query, body values first. Other content types leave
+// PostForm empty. The body is read through BodyReader, so it is bounded by the
+// body size limit, and can only be parsed once.
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+
+	r.PostForm = make(Query)
+	mediaType, _, err := r.contentType()
+	if err != nil {
+		return err
+	}
+	if mediaType == "application/x-www-form-urlencoded" {
+		body, err := io.ReadAll(r.BodyReader)
+		if err != nil {
+			return err
+		}
+		r.PostForm, err = ParseQuery(string(body))
+		if err != nil {
+			return err
+		}
+	}
+
+	r.Form = make(Query)
+	for key, values := range r.PostForm {
+		r.Form[key] = append(r.Form[key], values...)
+	}
+	if r.Target != nil {
+		for key, values := range r.Target.Query {
+			r.Form[key] = append(r.Form[key], values...)
+		}
+	}
+	return nil
+}
+
+// ParseMultipartForm parses a multipart/form-data body into MultipartForm,
+// streaming it from BodyReader. Up to maxMemory bytes are kept in memory, zero
+// meaning DefaultMaxFormMemory; larger file parts go to temp files that the
+// server removes once the handler returns. Value parts are also added to Form
+// and PostForm.
+func (r *Request) ParseMultipartForm(maxMemory int64) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+
+	mediaType, params, err := r.contentType()
+	if err != nil {
+		return err
+	}
+	if mediaType != "multipart/form-data" {
+		return ErrNotMultipart
+	}
+	boundary, ok := params["boundary"]
+	if !ok || len(boundary) == 0 {
+		return fmt.Errorf("multipart/form-data without a boundary")
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		return err
+	}
+
+	if maxMemory == 0 {
+		maxMemory = DefaultMaxFormMemory
+	}
+	form, err := multipart.NewReader(r.BodyReader, boundary).ReadForm(maxMemory)
+	if err != nil {
+		return err
+	}
+	r.MultipartForm = form
+
+	for key, values := range form.Value {
+		r.PostForm[key] = append(r.PostForm[key], values...)
+		r.Form[key] = append(r.Form[key], values...)
+	}
+	return nil
+}
+
+// FormValue returns the first value for key from the query or a form body,
+// parsing them if needed. Parse errors are ignored; call ParseForm or
+// ParseMultipartForm to see them.
+func (r *Request) FormValue(key string) string {
+	if r.Form == nil {
+		if r.ParseMultipartForm(0) != nil {
+			r.ParseForm()
+		}
+	}
+	return r.Form.Get(key)
+}
+
+// FormFile returns the first file uploaded under key in a multipart form.
+func (r *Request) FormFile(key string) (*multipart.FileHeader, error) {
+	if r.MultipartForm == nil {
+		err := r.ParseMultipartForm(0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	files := r.MultipartForm.File[key]
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file uploaded as '%s'", key)
+	}
+	return files[0], nil
+}
+
+func (r *Request) contentType() (string, map[string]string, error) {
+	value, ok := r.Headers.Get("Content-Type")
+	if !ok {
+		return "", nil, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid content-type '%s': %w", value, err)
+	}
+	return mediaType, params, nil
+}
@@ -0,0 +1,121 @@
+package request
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForm(t *testing.T) {
+	// Test: URL-encoded body merged with the query
+	body := "name=Ada+Lovelace&lang=en&lang=fr"
+	reader := &chunkReader{
+		data: "POST /submit?lang=de&page=2 HTTP/1.1\r\n" +
+			"Content-Type: application/x-www-form-urlencoded\r\n" +
+			"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+			"\r\n" +
+			body,
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, "Ada Lovelace", r.PostForm.Get("name"))
+	assert.Equal(t, []string{"en", "fr"}, r.PostForm["lang"])
+	assert.Equal(t, []string{"en", "fr", "de"}, r.Form["lang"])
+	assert.Equal(t, "2", r.FormValue("page"))
+	assert.Equal(t, "", r.PostForm.Get("page"))
+
+	// Test: Other content types only use the query
+	reader = &chunkReader{
+		data: "POST /submit?a=1 HTTP/1.1\r\n" +
+			"Content-Type: application/json\r\n" +
+			"Content-Length: 7\r\n" +
+			"\r\n" +
+			"{\"a\":2}",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "1", r.FormValue("a"))
+	assert.Equal(t, 0, len(r.PostForm))
+	require.ErrorIs(t, r.ParseMultipartForm(0), ErrNotMultipart)
+
+	// Test: Invalid encoding in the body
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Type: application/x-www-form-urlencoded\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"a=%zz",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.ErrorIs(t, r.ParseForm(), ErrInvalidEscape)
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	file := strings.Repeat("0123456789", 100)
+	body := "--XyZ\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n" +
+		"\r\n" +
+		"quarterly report\r\n" +
+		"--XyZ\r\n" +
+		"Content-Disposition: form-data; name=\"upload\"; filename=\"report.txt\"\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		file + "\r\n" +
+		"--XyZ--\r\n"
+	raw := "POST /upload?v=1 HTTP/1.1\r\n" +
+		"Content-Type: multipart/form-data; boundary=XyZ\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"\r\n" +
+		body
+
+	// Test: Streamed multipart body with the file spilled to disk
+	rr := NewReader(&chunkReader{data: raw, numBytesPerRead: 64})
+	rr.StreamBody = true
+	r, err := rr.ReadRequest()
+	require.NoError(t, err)
+	require.NoError(t, r.ParseMultipartForm(100))
+	assert.Equal(t, "quarterly report", r.FormValue("title"))
+	assert.Equal(t, "1", r.FormValue("v"))
+
+	fh, err := r.FormFile("upload")
+	require.NoError(t, err)
+	assert.Equal(t, "report.txt", fh.Filename)
+	assert.Equal(t, int64(len(file)), fh.Size)
+	f, err := fh.Open()
+	require.NoError(t, err)
+	content, err := io.ReadAll(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, file, string(content))
+	require.NoError(t, r.MultipartForm.RemoveAll())
+
+	_, err = r.FormFile("missing")
+	require.Error(t, err)
+
+	// Test: Missing boundary
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Type: multipart/form-data\r\n" +
+			"Content-Length: 0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.Error(t, r.ParseMultipartForm(0))
+
+	// Test: Body over the size limit never reaches the form parser
+	rr = NewReader(&chunkReader{data: raw, numBytesPerRead: 64})
+	rr.Limits = Limits{MaxBodyBytes: 100}
+	_, err = rr.ReadRequest()
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+}
@@ -0,0 +1,47 @@
+package request
+
+// Limits bounds the size of a request so a single client cannot exhaust the
+// server's memory. A zero field takes its value from DefaultLimits and a
+// negative one disables that limit.
+type Limits struct {
+	// MaxRequestLineBytes bounds the request line, CRLF excluded (414).
+	MaxRequestLineBytes int
+	// MaxHeaderBytes bounds the whole header section, and separately the
+	// trailer section of a chunked body (431).
+	MaxHeaderBytes int
+	// MaxHeaderCount bounds the number of header field lines (431).
+	MaxHeaderCount int
+	// MaxBodyBytes bounds the decoded body (413).
+	MaxBodyBytes int
+}
+
+var DefaultLimits = Limits{
+	MaxRequestLineBytes: 8 << 10,
+	MaxHeaderBytes:      64 << 10,
+	MaxHeaderCount:      100,
+	MaxBodyBytes:        10 << 20,
+}
+
+// maxChunkLineBytes bounds a chunk-size line, extensions included.
+const maxChunkLineBytes = 4 << 10
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxRequestLineBytes == 0 {
+		l.MaxRequestLineBytes = DefaultLimits.MaxRequestLineBytes
+	}
+	if l.MaxHeaderBytes == 0 {
+		l.MaxHeaderBytes = DefaultLimits.MaxHeaderBytes
+	}
+	if l.MaxHeaderCount == 0 {
+		l.MaxHeaderCount = DefaultLimits.MaxHeaderCount
+	}
+	if l.MaxBodyBytes == 0 {
+		l.MaxBodyBytes = DefaultLimits.MaxBodyBytes
+	}
+	return l
+}
+
+// exceeds reports whether n is over limit, where a negative limit means none.
+func exceeds(n, limit int) bool {
+	return limit >= 0 && n > limit
+}
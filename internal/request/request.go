@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"strconv"
 	"strings"
-	"unicode"
 
 	"github.com/CheeseFizz/httpfromtcp/internal/headers"
 )
@@ -19,26 +19,310 @@ const (
 	requestStateInitializing requestState = iota
 	requestStateParsingHeaders
 	requestStateParsingBody
+	requestStateParsingContent
+	requestStateParsingChunkSize
+	requestStateParsingChunkData
+	requestStateParsingChunkDataEnd
+	requestStateParsingTrailers
 	requestStateDone
 )
 
 type Request struct {
 	RequestLine RequestLine
-	state       requestState
-	Headers     headers.Headers
-	Body        []byte
+	// Target is RequestLine.RequestTarget parsed into its parts.
+	Target  *Target
+	state   requestState
+	Headers *headers.Headers
+	Body    []byte
+	// Trailers holds the fields sent after a chunked body, kept apart from
+	// Headers since they arrive after the handler could have acted on them.
+	Trailers *headers.Headers
+	// BodyReader reads the decoded body. When the Reader streams bodies it is
+	// the only way to get the body and Body stays empty; otherwise it reads
+	// over Body.
+	BodyReader io.ReadCloser
+	// Params holds the path parameters extracted by a router, if any.
+	Params map[string]string
+	// Form, PostForm and MultipartForm are filled in by ParseForm and
+	// ParseMultipartForm.
+	Form          Query
+	PostForm      Query
+	MultipartForm *multipart.Form
+
+	limits         Limits
+	streaming      bool
+	leadingCRLF    bool
+	leadingBytes   int
+	headersDone    bool
+	expectContinue bool
+	continued      bool
+	pending        []byte
+	bodyRead       int
+	headerBytes    int
+	headerCount    int
+	contentLength  int
+	chunkRemaining int
+}
+
+// bodyLength applies the message body length rules of RFC 9112 section 6.3 to
+// the request headers. It reports whether the body is chunked and, if not, its
+// length. Ambiguous framing is an error rather than a guess.
+func (r *Request) bodyLength() (chunked bool, length int, err error) {
+	te, hasTE := r.Headers.Get("Transfer-Encoding")
+	cl, hasCL := r.Headers.Get("Content-Length")
+
+	if hasTE {
+		if hasCL {
+			return false, 0, ErrContentLengthWithEncoding
+		}
+		// empty list elements are allowed and ignored (RFC 9110 §5.6.1.2)
+		codings := []string{}
+		for _, coding := range strings.Split(te, ",") {
+			coding = strings.TrimSpace(coding)
+			if coding != "" {
+				codings = append(codings, coding)
+			}
+		}
+		if len(codings) == 0 {
+			return false, 0, fmt.Errorf("%w: '%s'", ErrEmptyTransferEncoding, te)
+		}
+		for i, coding := range codings {
+			coding = strings.ToLower(coding)
+			if coding != "chunked" {
+				return false, 0, fmt.Errorf("%w: '%s'", ErrUnsupportedTransferCoding, coding)
+			}
+			if i != len(codings)-1 {
+				return false, 0, ErrChunkedNotFinal
+			}
+		}
+		return true, 0, nil
+	}
+
+	if !hasCL {
+		return false, 0, nil
+	}
+
+	// duplicate fields were comma-joined; they are only allowed if they agree
+	values := strings.Split(cl, ",")
+	for i, value := range values {
+		value = strings.TrimSpace(value)
+		if len(value) == 0 || strings.Trim(value, "0123456789") != "" {
+			return false, 0, fmt.Errorf("%w: '%s'", ErrInvalidContentLength, cl)
+		}
+		values[i] = value
+		if value != values[0] {
+			return false, 0, fmt.Errorf("%w: '%s'", ErrConflictingContentLength, cl)
+		}
+	}
+	length, err = strconv.Atoi(values[0])
+	if err != nil {
+		return false, 0, fmt.Errorf("%w: '%s'", ErrInvalidContentLength, cl)
+	}
+	return false, length, nil
+}
+
+// appendBody stores decoded body bytes, either in Body or, when streaming,
+// where BodyReader will pick them up.
+func (r *Request) appendBody(data []byte) {
+	if r.streaming {
+		r.pending = append(r.pending, data...)
+	} else {
+		r.Body = append(r.Body, data...)
+	}
+	r.bodyRead += len(data)
+}
+
+// checkHeaderSize accounts for the b bytes of data just consumed by a header or
+// trailer parse and checks the section against the limits, counting an
+// incomplete line still waiting in data.
+func (r *Request) checkHeaderSize(data []byte, b int, done bool) error {
+	r.headerBytes += b
+	if done {
+		// the section ended; the trailers get a fresh budget
+		r.headerBytes = 0
+		r.headerCount = 0
+		return nil
+	}
+	r.headerCount += bytes.Count(data[:b], []byte("\r\n"))
+
+	if exceeds(r.headerCount, r.limits.MaxHeaderCount) {
+		return fmt.Errorf("%w: more than %d fields", ErrHeadersTooLarge, r.limits.MaxHeaderCount)
+	}
+	pending := 0
+	if b == 0 {
+		pending = len(data)
+	}
+	if exceeds(r.headerBytes+pending, r.limits.MaxHeaderBytes) {
+		return fmt.Errorf("%w: more than %d bytes", ErrHeadersTooLarge, r.limits.MaxHeaderBytes)
+	}
+	return nil
+}
+
+// parseExpect checks the Expect header. 100-continue is the only expectation
+// defined, so anything else cannot be met. HTTP/1.0 clients cannot be sent a
+// 100 Continue, so their expectations are ignored.
+func (r *Request) parseExpect() error {
+	values := r.Headers.Values("Expect")
+	if len(values) == 0 || r.RequestLine.HttpVersion == "1.0" {
+		return nil
+	}
+	for _, value := range values {
+		if !strings.EqualFold(value, "100-continue") {
+			return fmt.Errorf("%w: '%s'", ErrExpectationFailed, value)
+		}
+	}
+	r.expectContinue = true
+	return nil
+}
+
+// ExpectsContinue reports whether the client is still waiting for a 100
+// Continue response before it sends the rest of the body.
+func (r *Request) ExpectsContinue() bool {
+	return r.expectContinue && !r.continued && r.state != requestStateDone
+}
+
+// KeepAlive reports whether the client asked for the connection to stay open
+// after this request: by default for HTTP/1.1, and only with "Connection:
+// keep-alive" for HTTP/1.0. An HTTP/1.0 request with Transfer-Encoding never
+// keeps it open, since its framing cannot be trusted (RFC 9112 §6.1).
+func (r *Request) KeepAlive() bool {
+	if r.Headers.ContainsToken("Connection", "close") {
+		return false
+	}
+	if r.RequestLine.HttpVersion == "1.0" {
+		if _, ok := r.Headers.Get("Transfer-Encoding"); ok {
+			return false
+		}
+		return r.Headers.ContainsToken("Connection", "keep-alive")
+	}
+	return true
+}
+
+// Param returns the named path parameter, or "" if it is not set.
+func (r *Request) Param(name string) string {
+	return r.Params[name]
+}
+
+// parseChunkSize parses a chunk-size line. Chunk extensions are checked against
+// the grammar but otherwise ignored.
+func parseChunkSize(line string) (int, error) {
+	size, ext := line, ""
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		size, ext = line[:i], line[i:]
+	}
+	if !validChunkExt(ext) {
+		return 0, fmt.Errorf("%w: invalid chunk extension '%s'", ErrBadChunk, line)
+	}
+	size = strings.TrimRight(size, " \t")
+	if len(size) == 0 || len(size) > 15 {
+		return 0, fmt.Errorf("%w: invalid chunk size '%s'", ErrBadChunk, line)
+	}
+	n, err := strconv.ParseUint(size, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid chunk size '%s'", ErrBadChunk, line)
+	}
+	return int(n), nil
+}
+
+// validChunkExt reports whether ext matches chunk-ext from RFC 9112 §7.1.1:
+//
+//	*( BWS ";" BWS token [ BWS "=" BWS ( token / quoted-string ) ] )
+//
+// Anything else, a bare CR or LF in particular, could be read differently by
+// another parser in front of this one.
+func validChunkExt(ext string) bool {
+	for {
+		ext = strings.TrimLeft(ext, " \t")
+		if ext == "" {
+			return true
+		}
+		if ext[0] != ';' {
+			return false
+		}
+		name, rest := cutToken(strings.TrimLeft(ext[1:], " \t"))
+		if name == "" {
+			return false
+		}
+		ext = strings.TrimLeft(rest, " \t")
+		if !strings.HasPrefix(ext, "=") {
+			continue
+		}
+		ext = strings.TrimLeft(ext[1:], " \t")
+		if strings.HasPrefix(ext, `"`) {
+			n := quotedStringLen(ext)
+			if n < 0 {
+				return false
+			}
+			ext = ext[n:]
+		} else {
+			value, rest := cutToken(ext)
+			if value == "" {
+				return false
+			}
+			ext = rest
+		}
+	}
+}
+
+// cutToken splits s after its leading run of token characters.
+func cutToken(s string) (token, rest string) {
+	i := 0
+	for i < len(s) && headers.ValidName(s[i:i+1]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// quotedStringLen returns the length of the quoted-string at the start of s, or
+// -1 if it is unterminated or contains a control character.
+func quotedStringLen(s string) int {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return i + 1
+		case c == '\\':
+			i++
+			if i == len(s) || s[i] < ' ' && s[i] != '\t' || s[i] == 0x7f {
+				return -1
+			}
+		case c < ' ' && c != '\t' || c == 0x7f:
+			return -1
+		}
+	}
+	return -1
 }
 
 func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.state {
 	case requestStateInitializing:
+		if bytes.HasPrefix(data, []byte("\r\n")) {
+			if !r.leadingCRLF {
+				return 0, fmt.Errorf("%w: empty line before request line", ErrBadRequestLine)
+			}
+			r.leadingBytes += len("\r\n")
+			if exceeds(r.leadingBytes, r.limits.MaxRequestLineBytes) {
+				return 0, ErrRequestLineTooLong
+			}
+			return len("\r\n"), nil
+		}
 		b, rline, err := parseRequestLine(data)
 		if err != nil {
 			return 0, err
 		} else if b == 0 {
+			if exceeds(len(data), r.limits.MaxRequestLineBytes) {
+				return 0, ErrRequestLineTooLong
+			}
 			return 0, nil
+		} else if exceeds(b-len("\r\n"), r.limits.MaxRequestLineBytes) {
+			return 0, ErrRequestLineTooLong
 		} else {
+			target, err := ParseTarget(rline.Method, rline.RequestTarget)
+			if err != nil {
+				return 0, err
+			}
 			r.RequestLine = *rline
+			r.Target = target
 			r.state = requestStateParsingHeaders
 			return b, nil
 		}
@@ -46,37 +330,112 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	case requestStateParsingHeaders:
 		b, done, err := r.Headers.Parse(data)
 		if err != nil {
-			return b, err
+			return b, fmt.Errorf("%w: %w", ErrBadHeader, err)
+		}
+		err = r.checkHeaderSize(data, b, done)
+		if err != nil {
+			return 0, err
 		}
 		if done {
+			err = r.parseExpect()
+			if err != nil {
+				return 0, err
+			}
 			r.state = requestStateParsingBody
 		}
 		return b, nil
 
 	case requestStateParsingBody:
-		content_length_str, ok := r.Headers.Get("Content-Length")
-		if !ok || content_length_str == string(rune(0)) {
+		chunked, content_length, err := r.bodyLength()
+		if err != nil {
+			return 0, err
+		}
+		if chunked {
+			r.state = requestStateParsingChunkSize
+			return 0, nil
+		}
+		if exceeds(content_length, r.limits.MaxBodyBytes) {
+			return 0, fmt.Errorf("%w: content-length %d", ErrBodyTooLarge, content_length)
+		}
+		if content_length == 0 {
 			// no content-length == no body to process
 			r.state = requestStateDone
 			return 0, nil
 		}
-
-		content_length, err := strconv.Atoi(content_length_str)
-		if err != nil {
-			return 0, err
+		r.contentLength = content_length
+		r.state = requestStateParsingContent
+		return 0, nil
+
+	case requestStateParsingContent:
+		// anything past the stated length belongs to the next request on the connection
+		remaining := r.contentLength - r.bodyRead
+		if len(data) > remaining {
+			data = data[:remaining]
 		}
 
-		r.Body = bytes.TrimRight(append(r.Body, data...), "\x00")
+		r.appendBody(data)
 
-		if len(r.Body) == content_length {
+		if r.bodyRead == r.contentLength {
 			r.state = requestStateDone
 		}
 
-		if len(r.Body) > content_length {
-			return 0, fmt.Errorf("content greater than stated length:\n%v\nlength: %v\ncontent-length: %v", r.Body, len(r.Body), content_length)
+		return len(data), nil
+
+	case requestStateParsingChunkSize:
+		line, _, found := strings.Cut(string(data), "\r\n")
+		if len(line) > maxChunkLineBytes {
+			return 0, fmt.Errorf("%w: chunk size line too long", ErrBadChunk)
 		}
+		if !found {
+			return 0, nil
+		}
+		size, err := parseChunkSize(line)
+		if err != nil {
+			return 0, err
+		}
+		if exceeds(r.bodyRead+size, r.limits.MaxBodyBytes) {
+			return 0, fmt.Errorf("%w: chunked body over %d bytes", ErrBodyTooLarge, r.limits.MaxBodyBytes)
+		}
+		if size == 0 {
+			r.state = requestStateParsingTrailers
+		} else {
+			r.chunkRemaining = size
+			r.state = requestStateParsingChunkData
+		}
+		return len(line) + len("\r\n"), nil
+
+	case requestStateParsingChunkData:
+		n := min(len(data), r.chunkRemaining)
+		r.appendBody(data[:n])
+		r.chunkRemaining -= n
+		if r.chunkRemaining == 0 {
+			r.state = requestStateParsingChunkDataEnd
+		}
+		return n, nil
 
-		return len(bytes.TrimRight(data, "\x00")), nil
+	case requestStateParsingChunkDataEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte("\r\n")) {
+			return 0, fmt.Errorf("%w: chunk data not followed by CRLF", ErrBadChunk)
+		}
+		r.state = requestStateParsingChunkSize
+		return 2, nil
+
+	case requestStateParsingTrailers:
+		b, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return b, fmt.Errorf("%w: %w", ErrBadHeader, err)
+		}
+		err = r.checkHeaderSize(data, b, done)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.state = requestStateDone
+		}
+		return b, nil
 
 	case requestStateDone:
 		return 0, fmt.Errorf("error: trying to read data in 'done' state")
@@ -90,14 +449,21 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 func (r *Request) parse(data []byte) (int, error) {
 	totalBytesParsed := 0
 	for r.state != requestStateDone {
+		state := r.state
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
 			return 0, err
 		}
-		if n == 0 {
+		totalBytesParsed += n
+		// a state change without consuming data still counts as progress
+		if n == 0 && r.state == state {
+			break
+		}
+		// hand control back once the headers are done so the caller can act
+		// before the body is consumed
+		if state == requestStateParsingHeaders && r.state != state {
 			break
 		}
-		totalBytesParsed += n
 	}
 	return totalBytesParsed, nil
 }
@@ -108,91 +474,226 @@ type RequestLine struct {
 	Method        string
 }
 
-func isUpper(s string) bool {
-	for _, r := range s {
-		if !unicode.IsUpper(r) && unicode.IsLetter(r) {
+// validTarget reports whether the request target is made of visible ASCII.
+// Anything else, control characters and raw non-ASCII bytes included, has to
+// be percent-encoded.
+func validTarget(target string) bool {
+	for i := 0; i < len(target); i++ {
+		if target[i] <= ' ' || target[i] >= 0x7f {
 			return false
 		}
 	}
 	return true
 }
 
+// validVersion reports whether s matches HTTP-version: "HTTP/" DIGIT "." DIGIT.
+func validVersion(s string) bool {
+	return len(s) == len("HTTP/1.1") && strings.HasPrefix(s, "HTTP/") &&
+		isDigit(s[5]) && s[6] == '.' && isDigit(s[7])
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// parseRequestLine parses request-line = method SP request-target SP
+// HTTP-version, as in RFC 9112 §3, with exactly one SP between the parts.
 func parseRequestLine(reqbytes []byte) (int, *RequestLine, error) {
-	lencrlf := len([]byte("\r\n"))
 	requestLine := RequestLine{}
 
-	reqstr := string(reqbytes)
-	rlines := (strings.Split(reqstr, "\r\n"))
-
-	// full request not in yet
-	if len(rlines) == 1 {
+	line, _, found := strings.Cut(string(reqbytes), "\r\n")
+	// full request line not in yet
+	if !found {
 		return 0, &requestLine, nil
 	}
 
-	// split request line into parts for validation
-	chunks := strings.Split(rlines[0], " ")
+	parts := strings.Split(line, " ")
+	if len(parts) != 3 {
+		return 0, &requestLine, fmt.Errorf("%w: %q", ErrBadRequestLine, line)
+	}
+	method, target, version := parts[0], parts[1], parts[2]
 
-	if len(chunks) > 3 {
-		return 0, &requestLine, fmt.Errorf("invalid http request line")
+	// methods are tokens, like field names, and case-sensitive
+	if !headers.ValidName(method) {
+		return 0, &requestLine, fmt.Errorf("%w: invalid http method %q", ErrBadRequestLine, method)
 	}
 
-	if !isUpper(chunks[0]) {
-		return 0, &requestLine, fmt.Errorf("invalid http method: %s", chunks[0])
+	if len(target) == 0 {
+		return 0, &requestLine, fmt.Errorf("%w: empty request target", ErrBadRequestLine)
+	}
+	if !validTarget(target) {
+		return 0, &requestLine, fmt.Errorf("%w: invalid character in %q", ErrInvalidTarget, target)
 	}
 
-	if chunks[2] != "HTTP/1.1" {
-		return 0, &requestLine, fmt.Errorf("unsupported http version: %s", chunks[2])
+	switch version {
+	case "HTTP/1.1", "HTTP/1.0":
+	default:
+		// a well-formed version we do not speak gets a 505 rather than a 400
+		if validVersion(version) {
+			return 0, &requestLine, fmt.Errorf("%w: %s", ErrUnsupportedVersion, version)
+		}
+		return 0, &requestLine, fmt.Errorf("%w: invalid http version %q", ErrBadRequestLine, version)
 	}
 
-	requestLine.HttpVersion = "1.1"
-	requestLine.Method = chunks[0]
-	requestLine.RequestTarget = chunks[1]
+	requestLine.HttpVersion = strings.TrimPrefix(version, "HTTP/")
+	requestLine.Method = method
+	requestLine.RequestTarget = target
 
-	return len([]byte(rlines[0])) + lencrlf, &requestLine, nil
+	return len(line) + len("\r\n"), &requestLine, nil
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
+// Reader parses consecutive requests from a single stream, such as a keep-alive
+// connection. Bytes read past the end of one request are kept for the next call
+// to ReadRequest.
+type Reader struct {
+	// Limits bounds each request read. The zero value uses DefaultLimits.
+	Limits Limits
+	// OnHeaders, if set, is called once the headers of a request are parsed and
+	// before any more of its body is read. An error aborts the request.
+	OnHeaders func(req *Request) error
+	// OnContinue, if set, is called for a request with "Expect: 100-continue"
+	// the first time more of its body has to be read from the stream, and
+	// should send the 100 Continue response. It is not called if the body is
+	// never read. An error aborts the request.
+	OnContinue func(req *Request) error
+	// AllowLeadingCRLF skips empty lines before a request line, which RFC 9112
+	// §2.2 suggests for robustness against clients that send an extra CRLF
+	// after a body. By default they are rejected with ErrBadRequestLine.
+	AllowLeadingCRLF bool
+	// StreamBody makes ReadRequest return as soon as the headers are parsed,
+	// leaving the body to be read through Request.BodyReader. That body must
+	// be read to the end or the request discarded with Discard before the next
+	// call to ReadRequest.
+	StreamBody bool
+
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+	eof         bool
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: reader,
+		buf:    make([]byte, bufferSize),
+	}
+}
+
+// WaitForRequest blocks until data for the next request is buffered, so callers
+// can tell an idle stream from one with a request in progress. It returns
+// io.EOF if the stream ends first.
+func (rr *Reader) WaitForRequest() error {
+	for rr.readToIndex == 0 {
+		if rr.eof {
+			return io.EOF
+		}
+		n, err := rr.reader.Read(rr.buf)
+		rr.readToIndex += n
+		if err == io.EOF {
+			rr.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadRequest parses the next request from the stream. It returns io.EOF if the
+// stream ends cleanly before any bytes of a new request arrive.
+func (rr *Reader) ReadRequest() (*Request, error) {
 	empty := Request{}
 	request := Request{
-		state: requestStateInitializing,
+		state:       requestStateInitializing,
+		limits:      rr.Limits.withDefaults(),
+		streaming:   rr.StreamBody,
+		leadingCRLF: rr.AllowLeadingCRLF,
 	}
 
 	request.Headers = headers.NewHeaders()
+	request.Trailers = headers.NewHeaders()
 	request.Body = make([]byte, 0)
 
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
-
 	for request.state != requestStateDone {
-		if len(buf) == readToIndex {
-			newbuf := make([]byte, 2*len(buf))
-			copy(newbuf, buf)
-			buf = newbuf
+		// a streamed request is handed over once its framing is known, so
+		// length errors are still returned here rather than from BodyReader
+		if request.streaming && request.headersDone && request.state > requestStateParsingBody {
+			request.BodyReader = &bodyReader{reader: rr, request: &request}
+			return &request, nil
+		}
+		err := rr.step(&request)
+		if err != nil {
+			return &empty, err
 		}
+	}
 
-		// get more data from reader
-		n, err := reader.Read(buf[readToIndex:])
-		if err == io.EOF {
-			if request.state != requestStateDone {
-				return &empty, fmt.Errorf("reached end of reader without reaching end of request")
-			}
-			break
+	// a streamed body that was already buffered in full is still in pending
+	if request.streaming {
+		request.BodyReader = &bodyReader{reader: rr, request: &request}
+	} else {
+		request.BodyReader = io.NopCloser(bytes.NewReader(request.Body))
+	}
+	return &request, nil
+}
+
+// step parses whatever is buffered for request and, if that made no progress,
+// reads more from the stream.
+func (rr *Reader) step(request *Request) error {
+	// try parsing first; a pipelined request may already be buffered
+	state := request.state
+	n, err := request.parse(rr.buf[:rr.readToIndex])
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		copy(rr.buf, rr.buf[n:rr.readToIndex])
+		rr.readToIndex -= n
+	}
+	if !request.headersDone && request.state > requestStateParsingHeaders {
+		request.headersDone = true
+		if rr.OnHeaders != nil {
+			return rr.OnHeaders(request)
 		}
-		readToIndex += n
+		return nil
+	}
+	if n > 0 || request.state != state || request.state == requestStateDone {
+		return nil
+	}
 
-		// try parsing; if successful remove old data from buffer
-		n, err = request.parse(buf)
-		if err != nil {
-			return &empty, err
+	if rr.eof {
+		if request.state == requestStateInitializing && rr.readToIndex == 0 {
+			return io.EOF
 		}
-		if n > 0 {
-			newbuf := make([]byte, len(buf))
-			copy(newbuf, buf[n:readToIndex])
-			buf = newbuf
-			readToIndex -= n
+		return fmt.Errorf("%w: reached end of reader without reaching end of request", io.ErrUnexpectedEOF)
+	}
+
+	// the client holds the body back until told to go on
+	if request.ExpectsContinue() && request.state > requestStateParsingBody {
+		request.continued = true
+		if rr.OnContinue != nil {
+			err = rr.OnContinue(request)
+			if err != nil {
+				return err
+			}
 		}
+	}
 
+	if len(rr.buf) == rr.readToIndex {
+		newbuf := make([]byte, 2*len(rr.buf))
+		copy(newbuf, rr.buf)
+		rr.buf = newbuf
 	}
 
-	return &request, nil
+	// get more data from reader
+	n, err = rr.reader.Read(rr.buf[rr.readToIndex:])
+	rr.readToIndex += n
+	if err == io.EOF {
+		rr.eof = true
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	return NewReader(reader).ReadRequest()
 }
@@ -1,9 +1,14 @@
 package request
 
 import (
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
 
+	"github.com/CheeseFizz/httpfromtcp/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -30,6 +35,12 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// get returns the value for key, or "" if it is not set
+func get(h *headers.Headers, key string) string {
+	value, _ := h.Get(key)
+	return value
+}
+
 func TestRequestLineParse(t *testing.T) {
 	// Test: Good GET Request line
 	reader := &chunkReader{
@@ -103,10 +114,10 @@ func TestHeaderParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, 3, len(r.Headers))
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, 3, r.Headers.Len())
+	assert.Equal(t, "localhost:42069", get(r.Headers, "host"))
+	assert.Equal(t, "curl/7.81.0", get(r.Headers, "user-agent"))
+	assert.Equal(t, "*/*", get(r.Headers, "accept"))
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -127,17 +138,20 @@ func TestHeaderParse(t *testing.T) {
 		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: \r\n\r\n",
 		numBytesPerRead: 3,
 	}
-	_, err = RequestFromReader(reader)
-	require.Error(t, err)
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	value, ok := r.Headers.Get("Accept")
+	assert.True(t, ok)
+	assert.Equal(t, "", value)
 
 	// Test: Duplicate Headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: application/json\r\n Accept: text/plain\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: application/json\r\nAccept: text/plain\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
-	assert.Equal(t, "application/json,text/plain", r.Headers["accept"])
+	assert.Equal(t, "application/json,text/plain", get(r.Headers, "accept"))
 
 	// Test: Missing End of Headers with Content
 	reader = &chunkReader{
@@ -219,3 +233,872 @@ func TestBodyParse(t *testing.T) {
 	require.NotNil(t, r)
 	assert.Equal(t, 0, len(r.Body))
 }
+
+func TestReaderKeepAlive(t *testing.T) {
+	// Test: Pipelined requests on one stream
+	reader := &chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	}
+	rr := NewReader(reader)
+	r, err := rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(r.Body))
+
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.Equal(t, 0, len(r.Body))
+
+	// Test: Clean end of stream between requests
+	_, err = rr.ReadRequest()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Whole pipeline delivered in a single read
+	reader = &chunkReader{
+		data: "GET /a HTTP/1.1\r\nHost: localhost:42069\r\n\r\n" +
+			"GET /b HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 1024,
+	}
+	rr = NewReader(reader)
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/a", r.RequestLine.RequestTarget)
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/b", r.RequestLine.RequestTarget)
+
+	// Test: Final data delivered together with EOF
+	rr = NewReader(iotest.DataErrReader(strings.NewReader("GET /a HTTP/1.1\r\n\r\n")))
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/a", r.RequestLine.RequestTarget)
+
+	// Test: OnHeaders runs before the body is read
+	reader = &chunkReader{
+		data:            "POST /a HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 1024,
+	}
+	rr = NewReader(reader)
+	called := false
+	rr.OnHeaders = func(req *Request) error {
+		called = true
+		assert.Equal(t, 0, len(req.Body))
+		return nil
+	}
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.True(t, called)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: OnHeaders error aborts the request
+	reader = &chunkReader{
+		data:            "POST /a HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 1024,
+	}
+	rr = NewReader(reader)
+	rr.OnHeaders = func(req *Request) error {
+		return io.ErrUnexpectedEOF
+	}
+	_, err = rr.ReadRequest()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Waiting on an empty stream
+	rr = NewReader(&chunkReader{data: "", numBytesPerRead: 3})
+	require.ErrorIs(t, rr.WaitForRequest(), io.EOF)
+}
+
+func TestChunkedBodyParse(t *testing.T) {
+	// Test: Chunked body
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n" +
+			"7\r\nworld!\n\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, 0, r.Trailers.Len())
+
+	// Test: Chunk extensions and trailers
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"a;name=value\r\n0123456789\r\n" +
+			"0;last\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(r.Body))
+	assert.Equal(t, "abc123", get(r.Trailers, "x-checksum"))
+	_, ok := r.Headers.Get("X-Checksum")
+	assert.False(t, ok)
+
+	// Test: Next request follows the chunked body
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nabc\r\n0\r\n\r\n" +
+			"GET /next HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 100,
+	}
+	rr := NewReader(reader)
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(r.Body))
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Signed chunk size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"-5\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Chunk data longer than its size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Missing last chunk
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Chunk extensions that follow the grammar
+	for _, ext := range []string{
+		";a", " ; a", ";a=b", ";a = b", ";a=\"b c\"", ";a=\"q\\\"\\\\\"", ";a;b=1;c", ";a ",
+	} {
+		reader = &chunkReader{
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"5" + ext + "\r\nhello\r\n0\r\n\r\n",
+			numBytesPerRead: 3,
+		}
+		r, err = RequestFromReader(reader)
+		require.NoError(t, err, ext)
+		assert.Equal(t, "hello", string(r.Body))
+	}
+
+	// Test: Malformed chunk extensions
+	for _, ext := range []string{
+		";a\nxx", ";a\rxx", ";a=b\nc", ";", ";=b", ";a=", ";a b", ";a=\"b", ";a=\"b\nc\"", ";a\x00", ";a=b\x7f", ";a,b",
+	} {
+		reader = &chunkReader{
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"5" + ext + "\r\nhello\r\n0\r\n\r\n",
+			numBytesPerRead: 3,
+		}
+		_, err = RequestFromReader(reader)
+		require.ErrorIs(t, err, ErrBadChunk, "%q", ext)
+	}
+}
+
+func TestSmugglingRejected(t *testing.T) {
+	corpus := []struct {
+		name    string
+		headers string
+		body    string
+		err     error
+	}{
+		{"CL.CL differing", "Content-Length: 5\r\nContent-Length: 10\r\n", "hello", ErrConflictingContentLength},
+		{"CL.CL comma list", "Content-Length: 5, 6\r\n", "hello", ErrConflictingContentLength},
+		{"CL.TE", "Content-Length: 6\r\nTransfer-Encoding: chunked\r\n", "0\r\n\r\nG", ErrContentLengthWithEncoding},
+		{"TE.CL", "Transfer-Encoding: chunked\r\nContent-Length: 3\r\n", "8\r\nSMUGGLED\r\n0\r\n\r\n", ErrContentLengthWithEncoding},
+		{"TE.TE duplicate chunked", "Transfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n", "0\r\n\r\n", ErrChunkedNotFinal},
+		{"chunked not final", "Transfer-Encoding: chunked, identity\r\n", "0\r\n\r\n", ErrChunkedNotFinal},
+		{"obfuscated coding", "Transfer-Encoding: xchunked\r\n", "0\r\n\r\n", ErrUnsupportedTransferCoding},
+		{"quoted coding", "Transfer-Encoding: \"chunked\"\r\n", "0\r\n\r\n", ErrUnsupportedTransferCoding},
+		{"empty list coding", "Transfer-Encoding: , ,\r\n", "0\r\n\r\n", ErrEmptyTransferEncoding},
+		{"empty list before other coding", "Transfer-Encoding: chunked, ,identity\r\n", "0\r\n\r\n", ErrChunkedNotFinal},
+		{"unsupported coding", "Transfer-Encoding: gzip, chunked\r\n", "0\r\n\r\n", ErrUnsupportedTransferCoding},
+		{"signed length", "Content-Length: +5\r\n", "hello", ErrInvalidContentLength},
+		{"negative length", "Content-Length: -1\r\n", "", ErrInvalidContentLength},
+		{"hex length", "Content-Length: 0x5\r\n", "hello", ErrInvalidContentLength},
+		{"spaced length", "Content-Length: 5 5\r\n", "hello", ErrInvalidContentLength},
+		{"overflowing length", "Content-Length: 99999999999999999999999\r\n", "", ErrInvalidContentLength},
+		{"empty length", "Content-Length:\r\n", "", ErrInvalidContentLength},
+		{"empty coding", "Transfer-Encoding: \r\n", "0\r\n\r\n", ErrEmptyTransferEncoding},
+		{"blank coding", "Transfer-Encoding: \t \r\n", "0\r\n\r\n", ErrEmptyTransferEncoding},
+		{"folded TE", "X-Pad: a\r\n Transfer-Encoding: chunked\r\n", "3\r\nabc\r\n0\r\n\r\n", ErrBadHeader},
+		{"folded CL", "X-Pad: a\r\n\tContent-Length: 5\r\n", "hello", ErrBadHeader},
+	}
+
+	for _, tc := range corpus {
+		reader := &chunkReader{
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				tc.headers +
+				"\r\n" +
+				tc.body,
+			numBytesPerRead: 3,
+		}
+		_, err := RequestFromReader(reader)
+		assert.ErrorIs(t, err, tc.err, tc.name)
+	}
+
+	// Test: Matching duplicate Content-Length is allowed
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Transfer coding names are case-insensitive
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: Chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: Empty list elements around chunked are skipped
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: , chunked,\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+}
+
+func TestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineBytes: 32,
+		MaxHeaderBytes:      64,
+		MaxHeaderCount:      3,
+		MaxBodyBytes:        10,
+	}
+	read := func(data string) (*Request, error) {
+		rr := NewReader(&chunkReader{data: data, numBytesPerRead: 3})
+		rr.Limits = limits
+		return rr.ReadRequest()
+	}
+
+	// Test: Within all limits
+	r, err := read("POST /submit HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\n0123456789")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(r.Body))
+
+	// Test: Request line too long
+	_, err = read("GET /" + strings.Repeat("a", 40) + " HTTP/1.1\r\n\r\n")
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Request line too long, never terminated
+	_, err = read("GET /" + strings.Repeat("a", 1000))
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Too many header fields
+	_, err = read("GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n")
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Header section too large
+	_, err = read("GET / HTTP/1.1\r\nA: " + strings.Repeat("a", 30) + "\r\nB: " + strings.Repeat("b", 30) + "\r\n\r\n")
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Single header line too large, never terminated
+	_, err = read("GET / HTTP/1.1\r\nA: " + strings.Repeat("a", 1000))
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Content-Length over the limit is rejected before the body arrives
+	rr := NewReader(&chunkReader{data: "POST / HTTP/1.1\r\nContent-Length: 11\r\n\r\n", numBytesPerRead: 3})
+	rr.Limits = limits
+	_, err = rr.ReadRequest()
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunked body over the limit
+	_, err = read("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhello \r\n6\r\nworld!\r\n0\r\n\r\n")
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Negative limit disables it
+	limits.MaxBodyBytes = -1
+	r, err = read("POST / HTTP/1.1\r\nContent-Length: 12\r\n\r\nhello world!")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(r.Body))
+
+	// Test: Zero limits use the defaults
+	rr = NewReader(&chunkReader{data: "GET /" + strings.Repeat("a", DefaultLimits.MaxRequestLineBytes) + " HTTP/1.1\r\n\r\n", numBytesPerRead: 1024})
+	_, err = rr.ReadRequest()
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+}
+
+func TestStreamBody(t *testing.T) {
+	// Test: Content-Length body streamed after the headers
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n" +
+			"GET /next HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	rr := NewReader(reader)
+	rr.StreamBody = true
+	r, err := rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(r.Body))
+	body, err := io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	assert.Equal(t, 0, len(r.Body))
+
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Chunked body streamed, with trailers once read
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n7\r\nworld!\n\r\n0\r\nX-Done: yes\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	rr = NewReader(reader)
+	rr.StreamBody = true
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	assert.Equal(t, "yes", get(r.Trailers, "x-done"))
+
+	// Test: Unread body discarded before the next request
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n" +
+			"GET /next HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	rr = NewReader(reader)
+	rr.StreamBody = true
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(r.BodyReader, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "he", string(buf))
+	require.NoError(t, r.BodyReader.Close())
+	_, err = r.BodyReader.Read(buf)
+	require.Error(t, err)
+	require.NoError(t, rr.Discard(r, -1))
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Discard gives up past its limit
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	rr = NewReader(reader)
+	rr.StreamBody = true
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	require.Error(t, rr.Discard(r, 4))
+
+	// Test: Truncated streamed body
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 20\r\n" +
+			"\r\n" +
+			"partial",
+		numBytesPerRead: 3,
+	}
+	rr = NewReader(reader)
+	rr.StreamBody = true
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Buffered requests still get a BodyReader
+	reader = &chunkReader{
+		data:            "POST /upload HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestBinaryBodyParse(t *testing.T) {
+	// Test: Body ending in NUL bytes
+	body := "\x08\x96\x01\x00\x00\x00"
+	for _, numBytesPerRead := range []int{1, 3, 8, 1024} {
+		reader := &chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Content-Length: 6\r\n" +
+				"\r\n" +
+				body,
+			numBytesPerRead: numBytesPerRead,
+		}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, []byte(body), r.Body)
+	}
+
+	// Test: Body of only NUL bytes followed by another request
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 4\r\n" +
+			"\r\n" +
+			"\x00\x00\x00\x00" +
+			"GET /next HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	rr := NewReader(reader)
+	r, err := rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0}, r.Body)
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Every byte value round-trips, plain and chunked
+	all := make([]byte, 0, 512)
+	for i := 0; i < 256; i++ {
+		all = append(all, byte(i))
+	}
+	for i := 255; i >= 0; i-- {
+		all = append(all, byte(i))
+	}
+	all = append(all, 0, 0, 0)
+
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: " + strconv.Itoa(len(all)) + "\r\n" +
+			"\r\n" +
+			string(all),
+		numBytesPerRead: 7,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, all, r.Body)
+
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"100\r\n" + string(all[:256]) + "\r\n" +
+			strconv.FormatInt(int64(len(all)-256), 16) + "\r\n" + string(all[256:]) + "\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 7,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, all, r.Body)
+
+	// Test: Streamed body ending in NUL bytes
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 6\r\n" +
+			"\r\n" +
+			body,
+		numBytesPerRead: 2,
+	}
+	rr = NewReader(reader)
+	rr.StreamBody = true
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	streamed, err := io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(body), streamed)
+}
+
+func TestTargetParse(t *testing.T) {
+	// Test: Origin form with query
+	target, err := ParseTarget("GET", "/search/caf%C3%A9?q=a+b&q=c%26d&empty=&flag")
+	require.NoError(t, err)
+	assert.Equal(t, TargetFormOrigin, target.Form)
+	assert.Equal(t, "/search/café", target.Path)
+	assert.Equal(t, "/search/caf%C3%A9", target.RawPath)
+	assert.Equal(t, "q=a+b&q=c%26d&empty=&flag", target.RawQuery)
+	assert.Equal(t, []string{"a b", "c&d"}, target.Query["q"])
+	assert.Equal(t, "a b", target.Query.Get("q"))
+	assert.Equal(t, []string{""}, target.Query["empty"])
+	assert.Equal(t, []string{""}, target.Query["flag"])
+	assert.Equal(t, "", target.Query.Get("missing"))
+
+	// Test: '+' is literal in the path
+	target, err = ParseTarget("GET", "/a+b")
+	require.NoError(t, err)
+	assert.Equal(t, "/a+b", target.Path)
+
+	// Test: Absolute form
+	target, err = ParseTarget("GET", "HTTP://www.example.org:8080/pub/WWW/?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, TargetFormAbsolute, target.Form)
+	assert.Equal(t, "http", target.Scheme)
+	assert.Equal(t, "www.example.org:8080", target.Host)
+	assert.Equal(t, "/pub/WWW/", target.Path)
+	assert.Equal(t, "1", target.Query.Get("x"))
+
+	// Test: Absolute form without a path
+	target, err = ParseTarget("GET", "http://www.example.org")
+	require.NoError(t, err)
+	assert.Equal(t, "/", target.Path)
+
+	// Test: Authority form
+	target, err = ParseTarget("CONNECT", "www.example.com:443")
+	require.NoError(t, err)
+	assert.Equal(t, TargetFormAuthority, target.Form)
+	assert.Equal(t, "www.example.com:443", target.Host)
+
+	// Test: Asterisk form
+	target, err = ParseTarget("OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, TargetFormAsterisk, target.Form)
+
+	// Test: Invalid targets
+	invalid := []struct{ method, target string }{
+		{"GET", "*"},
+		{"CONNECT", "/path"},
+		{"CONNECT", "www.example.com"},
+		{"GET", "www.example.com:443"},
+		{"GET", "1http://example.com/"},
+		{"GET", "http:///path"},
+		{"GET", "/path#fragment"},
+		{"GET", "/bad%zzescape"},
+		{"GET", "/truncated%4"},
+		{"GET", "/ok?bad=%g1"},
+		{"GET", "/ok?trailing=%"},
+	}
+	for _, tc := range invalid {
+		_, err = ParseTarget(tc.method, tc.target)
+		assert.ErrorIs(t, err, ErrInvalidTarget, tc.target)
+	}
+
+	// Test: Target parsed along with the request
+	reader := &chunkReader{
+		data:            "GET /coffee?size=large HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "/coffee", r.Target.Path)
+	assert.Equal(t, "large", r.Target.Query.Get("size"))
+
+	// Test: Invalid percent-encoding rejects the request
+	reader = &chunkReader{
+		data:            "GET /coffee%2 HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrInvalidTarget)
+}
+
+func TestCookies(t *testing.T) {
+	// Test: Cookies from the Cookie header
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nCookie: session=abc123; theme=dark\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(r.Cookies()))
+	c, err := r.Cookie("theme")
+	require.NoError(t, err)
+	assert.Equal(t, "dark", c.Value)
+	_, err = r.Cookie("missing")
+	require.ErrorIs(t, err, ErrNoCookie)
+
+	// Test: No Cookie header
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(r.Cookies()))
+}
+
+func TestExpectContinue(t *testing.T) {
+	head := "POST /upload HTTP/1.1\r\n" +
+		"Expect: 100-Continue\r\n" +
+		"Content-Length: 13\r\n" +
+		"\r\n"
+
+	// Test: Continue sent only once the streamed body is read
+	continues := 0
+	reader := &chunkReader{
+		data:            head + "hello world!\n",
+		numBytesPerRead: len(head),
+	}
+	rr := NewReader(reader)
+	rr.StreamBody = true
+	rr.OnContinue = func(req *Request) error {
+		continues++
+		return nil
+	}
+	r, err := rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, 0, continues)
+	assert.True(t, r.ExpectsContinue())
+	body, err := io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	assert.Equal(t, 1, continues)
+	assert.False(t, r.ExpectsContinue())
+
+	// Test: Buffered body continued once while it is read
+	continues = 0
+	reader = &chunkReader{
+		data:            head + "hello world!\n",
+		numBytesPerRead: len(head),
+	}
+	rr = NewReader(reader)
+	rr.OnContinue = func(req *Request) error {
+		continues++
+		return nil
+	}
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, 1, continues)
+
+	// Test: Oversize body rejected before continuing
+	continues = 0
+	reader = &chunkReader{
+		data:            head,
+		numBytesPerRead: len(head),
+	}
+	rr = NewReader(reader)
+	rr.Limits.MaxBodyBytes = 10
+	rr.OnContinue = func(req *Request) error {
+		continues++
+		return nil
+	}
+	_, err = rr.ReadRequest()
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+	assert.Equal(t, 0, continues)
+
+	// Test: Unknown expectation
+	reader = &chunkReader{
+		data:            "POST /upload HTTP/1.1\r\nExpect: 200-ok\r\nContent-Length: 1\r\n\r\nx",
+		numBytesPerRead: 5,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrExpectationFailed)
+}
+
+func TestMalformedRequests(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  string
+		err  error
+	}{
+		{"missing version", "GET /\r\n\r\n", ErrBadRequestLine},
+		{"too many parts", "GET / HTTP/1.1 extra\r\n\r\n", ErrBadRequestLine},
+		{"invalid method", "GE(T / HTTP/1.1\r\n\r\n", ErrBadRequestLine},
+		{"garbled version", "GET / HTTZ/1.1\r\n\r\n", ErrBadRequestLine},
+		{"unsupported version", "GET / HTTP/2.0\r\n\r\n", ErrUnsupportedVersion},
+		{"header without colon", "GET / HTTP/1.1\r\nHost localhost\r\n\r\n", ErrBadHeader},
+		{"control character in header", "GET / HTTP/1.1\r\nX-Test: a\x00b\r\n\r\n", ErrBadHeader},
+		{"bad chunk size", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", ErrBadChunk},
+		{"chunk without CRLF", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n1\r\naXX", ErrBadChunk},
+		{"bad trailer", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nbad trailer\r\n\r\n", ErrBadHeader},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := RequestFromReader(&chunkReader{data: tc.raw, numBytesPerRead: 3})
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestHTTP10(t *testing.T) {
+	// Test: HTTP/1.0 request line keeps its version
+	r, err := RequestFromReader(&chunkReader{data: "GET /old HTTP/1.0\r\nHost: localhost\r\n\r\n", numBytesPerRead: 5})
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.Equal(t, "/old", r.RequestLine.RequestTarget)
+
+	// Test: Persistence depends on the version
+	for _, tc := range []struct {
+		raw       string
+		keepAlive bool
+	}{
+		{"GET / HTTP/1.1\r\n\r\n", true},
+		{"GET / HTTP/1.1\r\nConnection: close\r\n\r\n", false},
+		{"GET / HTTP/1.0\r\n\r\n", false},
+		{"GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n", true},
+		{"GET / HTTP/1.0\r\nConnection: keep-alive, close\r\n\r\n", false},
+		{"POST / HTTP/1.0\r\nConnection: keep-alive\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", false},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", true},
+	} {
+		r, err := RequestFromReader(&chunkReader{data: tc.raw, numBytesPerRead: 5})
+		require.NoError(t, err)
+		assert.Equal(t, tc.keepAlive, r.KeepAlive(), "%q", tc.raw)
+	}
+
+	// Test: Expect is ignored for HTTP/1.0
+	reader := &chunkReader{
+		data:            "POST / HTTP/1.0\r\nExpect: 100-continue\r\nContent-Length: 2\r\n\r\nhi",
+		numBytesPerRead: 5,
+	}
+	rr := NewReader(reader)
+	rr.OnContinue = func(req *Request) error {
+		return fmt.Errorf("continue sent to HTTP/1.0 client")
+	}
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+	assert.Equal(t, "hi", string(r.Body))
+}
+
+func TestRequestLineConformance(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		line    string
+		method  string
+		target  string
+		version string
+		err     error
+	}{
+		{"simple", "GET / HTTP/1.1", "GET", "/", "1.1", nil},
+		{"extension method", "PROPFIND /dav HTTP/1.1", "PROPFIND", "/dav", "1.1", nil},
+		{"lowercase method is a token", "get / HTTP/1.1", "get", "/", "1.1", nil},
+		{"token punctuation in method", "M-SEARCH / HTTP/1.1", "M-SEARCH", "/", "1.1", nil},
+		{"query and escapes", "GET /a%20b?q=1&r=%2F HTTP/1.1", "GET", "/a%20b?q=1&r=%2F", "1.1", nil},
+		{"absolute form", "GET http://example.com/x HTTP/1.1", "GET", "http://example.com/x", "1.1", nil},
+		{"authority form", "CONNECT example.com:443 HTTP/1.1", "CONNECT", "example.com:443", "1.1", nil},
+		{"asterisk form", "OPTIONS * HTTP/1.1", "OPTIONS", "*", "1.1", nil},
+		{"http 1.0", "HEAD / HTTP/1.0", "HEAD", "/", "1.0", nil},
+
+		{"empty line", "", "", "", "", ErrBadRequestLine},
+		{"one part", "GET", "", "", "", ErrBadRequestLine},
+		{"two parts", "GET /", "", "", "", ErrBadRequestLine},
+		{"four parts", "GET / HTTP/1.1 x", "", "", "", ErrBadRequestLine},
+		{"double space", "GET  / HTTP/1.1", "", "", "", ErrBadRequestLine},
+		{"leading space", " GET / HTTP/1.1", "", "", "", ErrBadRequestLine},
+		{"trailing space", "GET / HTTP/1.1 ", "", "", "", ErrBadRequestLine},
+		{"tab separator", "GET\t/ HTTP/1.1", "", "", "", ErrBadRequestLine},
+		{"empty target", "GET  HTTP/1.1", "", "", "", ErrBadRequestLine},
+		{"method with separator", "GE(T / HTTP/1.1", "", "", "", ErrBadRequestLine},
+		{"method with slash", "/coffee GET HTTP/1.1", "", "", "", ErrBadRequestLine},
+		{"non-ascii method", "GÉT / HTTP/1.1", "", "", "", ErrBadRequestLine},
+		{"control character in target", "GET /a\x01b HTTP/1.1", "", "", "", ErrInvalidTarget},
+		{"delete character in target", "GET /a\x7fb HTTP/1.1", "", "", "", ErrInvalidTarget},
+		{"raw non-ascii target", "GET /café HTTP/1.1", "", "", "", ErrInvalidTarget},
+		{"bare CR in target", "GET /a\rb HTTP/1.1", "", "", "", ErrInvalidTarget},
+		{"lowercase version", "GET / http/1.1", "", "", "", ErrBadRequestLine},
+		{"short version", "GET / HTTP/1", "", "", "", ErrBadRequestLine},
+		{"unsupported major", "GET / HTTP/2.0", "", "", "", ErrUnsupportedVersion},
+		{"unsupported minor", "GET / HTTP/1.2", "", "", "", ErrUnsupportedVersion},
+		{"bad escape", "GET /%zz HTTP/1.1", "", "", "", ErrInvalidTarget},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := RequestFromReader(&chunkReader{data: tc.line + "\r\nHost: localhost\r\n\r\n", numBytesPerRead: 4})
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.method, r.RequestLine.Method)
+			assert.Equal(t, tc.target, r.RequestLine.RequestTarget)
+			assert.Equal(t, tc.version, r.RequestLine.HttpVersion)
+		})
+	}
+}
+
+func TestLeadingCRLF(t *testing.T) {
+	raw := "\r\n\r\nGET /late HTTP/1.1\r\n\r\n"
+
+	// Test: Rejected by default
+	_, err := RequestFromReader(&chunkReader{data: raw, numBytesPerRead: 3})
+	require.ErrorIs(t, err, ErrBadRequestLine)
+
+	// Test: Skipped when allowed, including after a body on a kept-alive stream
+	rr := NewReader(&chunkReader{
+		data:            raw + "POST /body HTTP/1.1\r\nContent-Length: 2\r\n\r\nhi\r\nGET /next HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	rr.AllowLeadingCRLF = true
+	for _, target := range []string{"/late", "/body", "/next"} {
+		r, err := rr.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, target, r.RequestLine.RequestTarget)
+	}
+	_, err = rr.ReadRequest()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Skipped lines count toward the request line limit
+	rr = NewReader(&chunkReader{data: strings.Repeat("\r\n", 20) + "GET / HTTP/1.1\r\n\r\n", numBytesPerRead: 7})
+	rr.AllowLeadingCRLF = true
+	rr.Limits.MaxRequestLineBytes = 16
+	_, err = rr.ReadRequest()
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+}
@@ -0,0 +1,201 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TargetForm is one of the four request-target forms of RFC 9112 section 3.2.
+type TargetForm int
+
+const (
+	// "/where?q=now", used by most requests
+	TargetFormOrigin TargetForm = iota
+	// "http://www.example.org/pub/WWW/", sent to proxies
+	TargetFormAbsolute
+	// "www.example.com:80", only for CONNECT
+	TargetFormAuthority
+	// "*", only for a server-wide OPTIONS
+	TargetFormAsterisk
+)
+
+// Query holds decoded query parameters. A key may repeat, so each maps to all
+// of its values in order.
+type Query map[string][]string
+
+// Get returns the first value for key, or "" if there is none.
+func (q Query) Get(key string) string {
+	values := q[key]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Target is a parsed request-target.
+type Target struct {
+	Form TargetForm
+	// Scheme is set for the absolute form.
+	Scheme string
+	// Host is the authority of the absolute and authority forms.
+	Host string
+	// Path is the percent-decoded path, and RawPath the path as sent.
+	Path    string
+	RawPath string
+	// RawQuery is the query as sent, without the '?'.
+	RawQuery string
+	Query    Query
+}
+
+// ParseTarget parses the request-target of a request with the given method,
+// checking that its form suits the method.
+func ParseTarget(method, raw string) (*Target, error) {
+	target := Target{Query: make(Query)}
+
+	switch {
+	case raw == "*":
+		if method != "OPTIONS" {
+			return nil, fmt.Errorf("%w: '*' is only allowed for OPTIONS", ErrInvalidTarget)
+		}
+		target.Form = TargetFormAsterisk
+		return &target, nil
+
+	case method == "CONNECT":
+		if len(raw) == 0 || strings.ContainsAny(raw, "/?#@") || !strings.Contains(raw, ":") {
+			return nil, fmt.Errorf("%w: CONNECT needs host:port, got '%s'", ErrInvalidTarget, raw)
+		}
+		target.Form = TargetFormAuthority
+		target.Host = raw
+		return &target, nil
+
+	case strings.HasPrefix(raw, "/"):
+		target.Form = TargetFormOrigin
+
+	default:
+		scheme, rest, found := strings.Cut(raw, "://")
+		if !found || !validScheme(scheme) {
+			return nil, fmt.Errorf("%w: '%s'", ErrInvalidTarget, raw)
+		}
+		target.Form = TargetFormAbsolute
+		target.Scheme = strings.ToLower(scheme)
+		i := strings.IndexAny(rest, "/?")
+		if i == -1 {
+			i = len(rest)
+		}
+		target.Host = rest[:i]
+		if len(target.Host) == 0 {
+			return nil, fmt.Errorf("%w: missing host in '%s'", ErrInvalidTarget, raw)
+		}
+		raw = rest[i:]
+		if !strings.HasPrefix(raw, "/") {
+			raw = "/" + raw
+		}
+	}
+
+	if strings.Contains(raw, "#") {
+		return nil, fmt.Errorf("%w: fragment in '%s'", ErrInvalidTarget, raw)
+	}
+
+	path, query, _ := strings.Cut(raw, "?")
+	decoded, err := unescape(path, false)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTarget, err)
+	}
+	target.Path = decoded
+	target.RawPath = path
+	target.RawQuery = query
+
+	target.Query, err = ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTarget, err)
+	}
+	return &target, nil
+}
+
+// ParseQuery decodes an application/x-www-form-urlencoded string such as a
+// query, where '+' stands for a space.
+func ParseQuery(raw string) (Query, error) {
+	query := make(Query)
+	if len(raw) == 0 {
+		return query, nil
+	}
+	for _, pair := range strings.Split(raw, "&") {
+		if len(pair) == 0 {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		key, err := unescape(key, true)
+		if err != nil {
+			return nil, err
+		}
+		value, err = unescape(value, true)
+		if err != nil {
+			return nil, err
+		}
+		query[key] = append(query[key], value)
+	}
+	return query, nil
+}
+
+// PathUnescape decodes the percent-encoding in a path or path segment.
+func PathUnescape(s string) (string, error) {
+	return unescape(s, false)
+}
+
+func validScheme(scheme string) bool {
+	if len(scheme) == 0 || !isAlpha(scheme[0]) {
+		return false
+	}
+	for i := 1; i < len(scheme); i++ {
+		c := scheme[i]
+		if !isAlpha(c) && !('0' <= c && c <= '9') && c != '+' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlpha(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// unescape decodes %XX sequences, and '+' as a space when plusSpace is set.
+func unescape(s string, plusSpace bool) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '%':
+			if i+2 >= len(s) {
+				return "", fmt.Errorf("%w: truncated escape in '%s'", ErrInvalidEscape, s)
+			}
+			hi, ok1 := unhex(s[i+1])
+			lo, ok2 := unhex(s[i+2])
+			if !ok1 || !ok2 {
+				return "", fmt.Errorf("%w: invalid escape '%s' in '%s'", ErrInvalidEscape, s[i:i+3], s)
+			}
+			sb.WriteByte(hi<<4 | lo)
+			i += 2
+		case s[i] == '+' && plusSpace:
+			sb.WriteByte(' ')
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String(), nil
+}
@@ -10,16 +10,9 @@ import (
 
 type StatusCode int
 
-const (
-	status200 StatusCode = 200
-	status400 StatusCode = 400
-	status500 StatusCode = 500
-)
-
-func GetDefaultHeaders(contentLen int) headers.Headers {
+func GetDefaultHeaders(contentLen int) *headers.Headers {
 	new_headers := headers.NewHeaders()
 	hbytes := []byte(fmt.Sprintf("Content-Length: %d\r\n", contentLen) +
-		"Connection: close\r\n" +
 		"Content-Type: text/plain\r\n" +
 		"\r\n")
 	_, _, err := new_headers.Parse(hbytes)
@@ -30,40 +23,45 @@ func GetDefaultHeaders(contentLen int) headers.Headers {
 	return new_headers
 }
 
+// WriteStatusLine writes the HTTP/1.1 status line for statusCode with its
+// reason phrase. Unregistered codes get an empty reason, which RFC 9112 allows;
+// codes outside 100 to 599 are refused.
 func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
-	switch statusCode {
-	case status200:
-		_, err := w.Write([]byte("HTTP/1.1 200 OK\r\n"))
-		if err != nil {
-			return err
-		}
-	case status400:
-		_, err := w.Write([]byte("HTTP/1.1 400 Bad Request\r\n"))
-		if err != nil {
-			return err
-		}
-	case status500:
-		_, err := w.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n"))
-		if err != nil {
-			return err
-		}
-	default:
-		_, err := fmt.Fprintf(w, "HTTP/1.1 %d \r\n", statusCode)
-		if err != nil {
-			return err
-		}
+	return writeStatusLine(w, "1.1", statusCode)
+}
+
+// writeStatusLine is WriteStatusLine for the given HTTP version, "1.0" or
+// "1.1".
+func writeStatusLine(w io.Writer, version string, statusCode StatusCode) error {
+	if !statusCode.Valid() {
+		return fmt.Errorf("invalid status code %d", statusCode)
+	}
+	_, err := fmt.Fprintf(w, "HTTP/%s %d %s\r\n", version, int(statusCode), StatusText(statusCode))
+	if err != nil {
+		return err
 	}
 	return nil
 }
 
-func WriteHeaders(w io.Writer, headers headers.Headers) error {
-	for key, value := range headers {
-		_, err := fmt.Fprintf(w, "%s: %s\r\n", key, value)
+// WriteHeaders writes the header section; nil headers write an empty one.
+// Nothing is written if any field has an invalid name or value, since one
+// containing a CR or LF could inject fields or split the response.
+func WriteHeaders(w io.Writer, headers *headers.Headers) error {
+	if headers == nil {
+		_, err := fmt.Fprint(w, "\r\n")
+		return err
+	}
+	err := headers.Validate()
+	if err != nil {
+		return err
+	}
+	for key, value := range headers.All() {
+		_, err = fmt.Fprintf(w, "%s: %s\r\n", key, value)
 		if err != nil {
 			return err
 		}
 	}
-	_, err := fmt.Fprint(w, "\r\n")
+	_, err = fmt.Fprint(w, "\r\n")
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,423 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/CheeseFizz/httpfromtcp/internal/cookie"
+	"github.com/CheeseFizz/httpfromtcp/internal/headers"
+)
+
+// ErrContentLength is returned for body bytes beyond the declared
+// Content-Length.
+var ErrContentLength = errors.New("body longer than declared Content-Length")
+
+type writerState int
+
+const (
+	writerStateStatusLine writerState = iota
+	writerStateHeaders
+	writerStateBody
+	writerStateTrailers
+	writerStateDone
+)
+
+// Writer writes a single response and enforces the order of its parts:
+// status line, then headers, then body, then trailers for a chunked body.
+// Anything the handler leaves unwritten is filled in with defaults by Finish.
+type Writer struct {
+	writer     io.Writer
+	state      writerState
+	statusCode StatusCode
+	headers    *headers.Headers
+	extra      *headers.Headers
+	pending    bytes.Buffer
+	keepAlive  bool
+	head       bool
+	version    string
+	chunked    bool
+	// contentLength is the declared length of the body, or -1 if there is
+	// none; written counts the body bytes sent against it
+	contentLength int64
+	written       int64
+	// unchunked is set when a chunked body is sent as is to an HTTP/1.0
+	// client, which does not know the chunked coding
+	unchunked bool
+	cookies   []*cookie.Cookie
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		writer:        w,
+		state:         writerStateStatusLine,
+		extra:         headers.NewHeaders(),
+		keepAlive:     true,
+		version:       "1.1",
+		contentLength: -1,
+	}
+}
+
+// SetVersion sets the HTTP version of the response to match the request's,
+// "1.0" or "1.1". The default is "1.1". An HTTP/1.0 response is only kept
+// alive with an explicit "Connection: keep-alive", and a chunked body is sent
+// without the coding and ended by closing the connection.
+func (w *Writer) SetVersion(version string) {
+	w.version = version
+}
+
+// SetKeepAlive tells the writer whether the server intends to keep the
+// connection open. When false, a "Connection: close" header is added to the
+// response unless the handler set a Connection header itself.
+func (w *Writer) SetKeepAlive(keepAlive bool) {
+	w.keepAlive = keepAlive
+}
+
+// SetHead tells the writer the response answers a HEAD request. The headers are
+// sent as they would be for GET, including the Content-Length of a held-back
+// body, but the body itself is dropped.
+func (w *Writer) SetHead(head bool) {
+	w.head = head
+}
+
+// bodyAllowed reports whether the response may carry a body. HEAD responses
+// and 1xx, 204 and 304 responses never do.
+func (w *Writer) bodyAllowed() bool {
+	switch {
+	case w.head, w.statusCode.Informational():
+		return false
+	case w.statusCode == StatusNoContent, w.statusCode == StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// KeepAlive reports whether the connection can be reused once the response is
+// finished, given the headers that were sent.
+func (w *Writer) KeepAlive() bool {
+	if !w.keepAlive || w.headers == nil {
+		return w.keepAlive
+	}
+	if w.headers.ContainsToken("Connection", "close") {
+		return false
+	}
+	if w.version == "1.0" && !w.headers.ContainsToken("Connection", "keep-alive") {
+		return false
+	}
+	if w.chunked || !w.bodyAllowed() {
+		return true
+	}
+	// without a length the body is delimited by closing the connection, and
+	// a short one would make the next response part of it
+	return w.contentLength >= 0 && w.written == w.contentLength
+}
+
+// Status returns the status code written, or 0 if the status line has not been
+// written yet.
+func (w *Writer) Status() StatusCode {
+	return w.statusCode
+}
+
+// Header returns fields to add to the response headers, whether the handler
+// writes them or Finish fills in defaults. A field the written headers already
+// have is left as the handler set it. This lets middleware add headers around
+// any handler; changes after the headers are written have no effect.
+func (w *Writer) Header() *headers.Headers {
+	return w.extra
+}
+
+func (w *Writer) StatusWritten() bool {
+	return w.state > writerStateStatusLine
+}
+
+func (w *Writer) HeadersWritten() bool {
+	return w.state > writerStateHeaders
+}
+
+// WriteStatusLine sets the status line of the final response. It is held back
+// and sent together with the headers, so that headers failing validation leave
+// nothing sent and Reset can still replace the response. 1xx codes are refused
+// here; use WriteInformational for those.
+func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	if w.state != writerStateStatusLine {
+		return fmt.Errorf("status line already written")
+	}
+	if statusCode.Informational() {
+		return fmt.Errorf("informational status %d must be written with WriteInformational", statusCode)
+	}
+	if !statusCode.Valid() {
+		return fmt.Errorf("invalid status code %d", statusCode)
+	}
+	w.statusCode = statusCode
+	w.state = writerStateHeaders
+	return nil
+}
+
+// WriteInformational sends a complete 1xx interim response with the given
+// headers, which may be nil. It does not end the exchange: any number may be
+// sent before the final response is started with WriteStatusLine.
+func (w *Writer) WriteInformational(statusCode StatusCode, h *headers.Headers) error {
+	if w.state != writerStateStatusLine {
+		return fmt.Errorf("informational response after status line")
+	}
+	if !statusCode.Informational() {
+		return fmt.Errorf("status %d is not informational", statusCode)
+	}
+	if w.version == "1.0" {
+		return fmt.Errorf("informational responses cannot be sent to HTTP/1.0 clients")
+	}
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+	err := h.Validate()
+	if err != nil {
+		return err
+	}
+	err = writeStatusLine(w.writer, w.version, statusCode)
+	if err != nil {
+		return err
+	}
+	return WriteHeaders(w.writer, h)
+}
+
+func (w *Writer) WriteHeaders(h *headers.Headers) error {
+	switch w.state {
+	case writerStateStatusLine:
+		return fmt.Errorf("status line must be written before headers")
+	case writerStateHeaders:
+	default:
+		return fmt.Errorf("headers already written")
+	}
+
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+	sent := h.Clone()
+	for key, value := range w.extra.All() {
+		if _, ok := h.Get(key); !ok {
+			sent.Add(key, value)
+		}
+	}
+	if w.statusCode == StatusNoContent {
+		// a 204 must not declare a length
+		sent.Del("Content-Length")
+		sent.Del("Transfer-Encoding")
+	}
+	if w.version == "1.0" && sent.ContainsToken("Transfer-Encoding", "chunked") {
+		sent.Del("Transfer-Encoding")
+		sent.Del("Trailer")
+		w.unchunked = true
+		w.keepAlive = false
+	}
+	if _, ok := sent.Get("Connection"); !ok {
+		if !w.keepAlive {
+			sent.Set("Connection", "close")
+		} else if w.version == "1.0" {
+			// HTTP/1.0 connections close unless told otherwise
+			sent.Set("Connection", "keep-alive")
+		}
+	}
+	// each cookie needs its own field line; joined with commas they would be
+	// misread since Expires contains a comma
+	for _, c := range w.cookies {
+		sent.Add("Set-Cookie", c.String())
+	}
+
+	var head bytes.Buffer
+	err := writeStatusLine(&head, w.version, w.statusCode)
+	if err != nil {
+		return err
+	}
+	err = WriteHeaders(&head, sent)
+	if err != nil {
+		return err
+	}
+	_, err = w.writer.Write(head.Bytes())
+	if err != nil {
+		return err
+	}
+	w.headers = sent
+	w.chunked = sent.ContainsToken("Transfer-Encoding", "chunked")
+	if value, ok := sent.Get("Content-Length"); ok && !w.chunked {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err == nil && n >= 0 {
+			w.contentLength = n
+		}
+	}
+	w.state = writerStateBody
+
+	// body written before the headers was held back; send it now
+	if w.pending.Len() > 0 {
+		_, err = w.Write(w.pending.Bytes())
+		w.pending.Reset()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetCookie adds a Set-Cookie field for c to the response headers. It must be
+// called before the headers are written, and fails for an invalid cookie.
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if w.HeadersWritten() {
+		return fmt.Errorf("cannot set cookie after headers are written")
+	}
+	err := c.Valid()
+	if err != nil {
+		return err
+	}
+	w.cookies = append(w.cookies, c)
+	return nil
+}
+
+// WriteBody writes p as is. For a response that cannot have a body p is
+// dropped, but reported as written. If the headers declared a Content-Length,
+// nothing is written and ErrContentLength is returned when p would go past it.
+func (w *Writer) WriteBody(p []byte) (int, error) {
+	if w.state != writerStateBody {
+		return 0, fmt.Errorf("headers must be written before the body")
+	}
+	if !w.bodyAllowed() {
+		return len(p), nil
+	}
+	if w.contentLength >= 0 && w.written+int64(len(p)) > w.contentLength {
+		return 0, fmt.Errorf("%w: %d bytes declared", ErrContentLength, w.contentLength)
+	}
+	n, err := w.writer.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// Write implements io.Writer. Until the headers are written the bytes are held
+// back so Finish can send a matching Content-Length; after that it is the same
+// as WriteChunkedBody if the headers declared a chunked body, or WriteBody if
+// not.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.state < writerStateBody {
+		return w.pending.Write(p)
+	}
+	if w.chunked {
+		return w.WriteChunkedBody(p)
+	}
+	return w.WriteBody(p)
+}
+
+// WriteChunkedBody writes p as a single chunk. An empty p writes nothing, since
+// a zero-size chunk would end the body.
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.state != writerStateBody {
+		return 0, fmt.Errorf("headers must be written before the body")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !w.bodyAllowed() {
+		return len(p), nil
+	}
+	if w.unchunked {
+		return w.writer.Write(p)
+	}
+
+	_, err := fmt.Fprintf(w.writer, "%X\r\n", len(p))
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = w.writer.Write([]byte("\r\n"))
+	if err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// WriteChunkedBodyDone writes the last-chunk. The response must then be ended
+// with WriteTrailers, or Finish if there are none.
+func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if w.state != writerStateBody {
+		return 0, fmt.Errorf("headers must be written before the body")
+	}
+	if !w.bodyAllowed() || w.unchunked {
+		w.state = writerStateTrailers
+		return 0, nil
+	}
+	n, err := w.writer.Write([]byte("0\r\n"))
+	if err != nil {
+		return n, err
+	}
+	w.state = writerStateTrailers
+	return n, nil
+}
+
+// WriteTrailers writes the trailer fields following a chunked body, which may
+// be nil, and ends the response.
+func (w *Writer) WriteTrailers(h *headers.Headers) error {
+	if w.state != writerStateTrailers {
+		return fmt.Errorf("chunked body must be done before trailers")
+	}
+	if !w.bodyAllowed() || w.unchunked {
+		w.state = writerStateDone
+		return nil
+	}
+	err := WriteHeaders(w.writer, h)
+	if err != nil {
+		return err
+	}
+	w.state = writerStateDone
+	return nil
+}
+
+// Reset discards what is held back: any body from Write, and a status line
+// whose headers have not been written. It has no effect on anything already
+// sent.
+func (w *Writer) Reset() {
+	w.pending.Reset()
+	if w.state == writerStateHeaders {
+		w.statusCode = 0
+		w.state = writerStateStatusLine
+	}
+}
+
+// Finish writes whatever part of the response the handler did not: the status
+// line (using statusCode), default headers sized for the held-back body, and
+// that body, or the end of an unfinished chunked body. No further writes are
+// allowed afterward.
+func (w *Writer) Finish(statusCode StatusCode) error {
+	if w.state == writerStateStatusLine {
+		err := w.WriteStatusLine(statusCode)
+		if err != nil {
+			return err
+		}
+	}
+	if w.state == writerStateHeaders {
+		h := GetDefaultHeaders(w.pending.Len())
+		// there is no content to describe, unless a 304 was given the body a
+		// 200 would have had
+		if w.statusCode == StatusNoContent || w.statusCode == StatusNotModified && w.pending.Len() == 0 {
+			h = headers.NewHeaders()
+		}
+		err := w.WriteHeaders(h)
+		if err != nil {
+			return err
+		}
+	}
+	if w.state == writerStateBody && w.chunked {
+		_, err := w.WriteChunkedBodyDone()
+		if err != nil {
+			return err
+		}
+	}
+	if w.state == writerStateTrailers {
+		err := w.WriteTrailers(headers.NewHeaders())
+		if err != nil {
+			return err
+		}
+	}
+	w.state = writerStateDone
+	return nil
+}
@@ -0,0 +1,348 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CheeseFizz/httpfromtcp/internal/cookie"
+	"github.com/CheeseFizz/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	// Test: Defaults filled in for held-back body
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(200))
+	assert.Contains(t, buf.String(), "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, buf.String(), "Content-Length: 5\r\n")
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nhello")))
+	assert.True(t, w.KeepAlive())
+
+	// Test: Handler-written status keeps default headers
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(201))
+	_, err = w.Write([]byte("made"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(200))
+	assert.Contains(t, buf.String(), "HTTP/1.1 201 Created\r\n")
+	assert.Contains(t, buf.String(), "Content-Length: 4\r\n")
+
+	// Test: Headers before status line
+	w = NewWriter(&bytes.Buffer{})
+	require.Error(t, w.WriteHeaders(headers.NewHeaders()))
+
+	// Test: Nil headers are an empty set
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.Header().Set("X-Extra", "1")
+	require.NoError(t, w.WriteStatusLine(200))
+	require.NoError(t, w.WriteHeaders(nil))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nX-Extra: 1\r\n\r\n", buf.String())
+
+	// Test: Body before headers
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(200))
+	_, err = w.WriteBody([]byte("too soon"))
+	require.Error(t, err)
+
+	// Test: Status line twice
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(200))
+	require.Error(t, w.WriteStatusLine(200))
+
+	// Test: Connection close added when not keeping alive
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetKeepAlive(false)
+	require.NoError(t, w.Finish(200))
+	assert.Contains(t, buf.String(), "Connection: close\r\n")
+	assert.False(t, w.KeepAlive())
+
+	// Test: Handler headers without a length end the connection
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(200))
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/plain")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.False(t, w.KeepAlive())
+
+	// Test: Body checked against the declared length
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(200))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	_, err = w.Write([]byte("half"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(200))
+	assert.False(t, w.KeepAlive())
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(200))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(4)))
+	_, err = w.Write([]byte("four"))
+	require.NoError(t, err)
+	assert.True(t, w.KeepAlive())
+	n, err := w.Write([]byte("more"))
+	require.ErrorIs(t, err, ErrContentLength)
+	assert.Equal(t, 0, n)
+	assert.True(t, w.KeepAlive())
+
+	// Test: Chunked body with trailers
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(200))
+	h = headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Content-Length")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hello world"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("!"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Content-Length", "12")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nB\r\nhello world\r\n1\r\n!\r\n0\r\nX-Content-Length: 12\r\n\r\n")))
+	assert.True(t, w.KeepAlive())
+
+	// Test: Trailers before the last chunk
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(200))
+	require.NoError(t, w.WriteHeaders(h))
+	require.Error(t, w.WriteTrailers(trailers))
+
+	// Test: Nil trailers end the body with none
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(200))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("abc"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(nil))
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("3\r\nabc\r\n0\r\n\r\n")))
+	buf = &bytes.Buffer{}
+	require.NoError(t, WriteHeaders(buf, nil))
+	assert.Equal(t, "\r\n", buf.String())
+
+	// Test: Finish ends an unfinished chunked body
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(200))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("abc"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(200))
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("3\r\nabc\r\n0\r\n\r\n")))
+
+	// Test: One Set-Cookie line per cookie
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "a", Value: "1", Expires: time.Date(2030, time.January, 2, 0, 0, 0, 0, time.UTC)}))
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	require.Error(t, w.SetCookie(&cookie.Cookie{Name: "c", Value: "evil\r\nX-Injected: 1"}))
+	require.NoError(t, w.Finish(200))
+	assert.Contains(t, buf.String(), "Set-Cookie: a=1; Expires=Wed, 02 Jan 2030 00:00:00 GMT\r\n")
+	assert.Contains(t, buf.String(), "Set-Cookie: b=2; HttpOnly\r\n")
+	assert.NotContains(t, buf.String(), "X-Injected")
+	require.Error(t, w.SetCookie(&cookie.Cookie{Name: "d", Value: "4"}))
+
+	// Test: Headers written in order with the casing they were set with
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	h = headers.NewHeaders()
+	h.Set("Content-Length", "0")
+	h.Add("x-trace", "a")
+	h.Add("X-Trace", "b")
+	h.Set("ETag", `"v1"`)
+	require.NoError(t, w.WriteStatusLine(200))
+	require.NoError(t, w.WriteHeaders(h))
+	assert.True(t, strings.HasSuffix(buf.String(), "Content-Length: 0\r\nx-trace: a\r\nX-Trace: b\r\nETag: \"v1\"\r\n\r\n"))
+
+	// Test: Header injection is refused without writing anything
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	h = GetDefaultHeaders(0)
+	h.Set("Location", "/next\r\nSet-Cookie: evil=1")
+	require.NoError(t, w.WriteStatusLine(302))
+	require.ErrorIs(t, w.WriteHeaders(h), headers.ErrInvalidFieldValue)
+	assert.Equal(t, 0, buf.Len())
+	assert.False(t, w.HeadersWritten())
+	require.NoError(t, w.Finish(302))
+	assert.NotContains(t, buf.String(), "evil")
+
+	// Test: Reset after refused headers replaces the status
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(302))
+	require.Error(t, w.WriteHeaders(h))
+	w.Reset()
+	assert.False(t, w.StatusWritten())
+	_, err = w.Write([]byte("oops"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(500))
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.NotContains(t, buf.String(), "302")
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\noops")))
+}
+
+func TestStatusLine(t *testing.T) {
+	// Test: Reason phrases for registered codes
+	for code, line := range map[StatusCode]string{
+		StatusOK:                      "HTTP/1.1 200 OK\r\n",
+		StatusNoContent:               "HTTP/1.1 204 No Content\r\n",
+		StatusTeapot:                  "HTTP/1.1 418 I'm a teapot\r\n",
+		StatusHTTPVersionNotSupported: "HTTP/1.1 505 HTTP Version Not Supported\r\n",
+	} {
+		buf := &bytes.Buffer{}
+		require.NoError(t, WriteStatusLine(buf, code))
+		assert.Equal(t, line, buf.String())
+	}
+
+	// Test: Unregistered code in range gets an empty reason
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteStatusLine(buf, 299))
+	assert.Equal(t, "HTTP/1.1 299 \r\n", buf.String())
+	assert.Equal(t, "", StatusText(299))
+
+	// Test: Codes outside 100-599 are refused
+	for _, code := range []StatusCode{0, 99, 600, -200} {
+		buf = &bytes.Buffer{}
+		require.Error(t, WriteStatusLine(buf, code))
+		assert.Equal(t, 0, buf.Len())
+	}
+	w := NewWriter(&bytes.Buffer{})
+	require.Error(t, w.WriteStatusLine(1000))
+	assert.False(t, w.StatusWritten())
+
+	// Test: Informational responses precede the final one
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.Error(t, w.WriteStatusLine(StatusContinue))
+	require.Error(t, w.WriteInformational(StatusOK, nil))
+	require.NoError(t, w.WriteInformational(StatusContinue, nil))
+	h := headers.NewHeaders()
+	h.Set("Link", "</style.css>; rel=preload")
+	require.NoError(t, w.WriteInformational(StatusEarlyHints, h))
+	assert.False(t, w.StatusWritten())
+	_, err := w.Write([]byte("done"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(StatusOK))
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\nHTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\ndone"))
+	require.Error(t, w.WriteInformational(StatusContinue, nil))
+}
+
+func TestBodylessResponses(t *testing.T) {
+	// Test: HEAD keeps the Content-Length a GET would have sent
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetHead(true)
+	n, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	require.NoError(t, w.Finish(StatusOK))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: HEAD with a chunked body sends only the headers
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetHead(true)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Content-Length")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Content-Length", "5")
+	require.NoError(t, w.WriteTrailers(trailers))
+	require.NoError(t, w.Finish(StatusOK))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Content-Length\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: 204 gets neither a body nor a length
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.Write([]byte("ignored"))
+	require.NoError(t, w.Finish(StatusNoContent))
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(3)))
+	_, err = w.WriteBody([]byte("abc"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\nContent-Type: text/plain\r\n\r\n", buf.String())
+
+	// Test: 304 keeps the handler's headers but drops the body
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	h = headers.NewHeaders()
+	h.Set("ETag", `"v1"`)
+	h.Set("Content-Length", "5")
+	require.NoError(t, w.WriteStatusLine(StatusNotModified))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish(StatusNotModified))
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\nETag: \"v1\"\r\nContent-Length: 5\r\n\r\n", buf.String())
+
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.Finish(StatusNotModified))
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\n\r\n", buf.String())
+}
+
+func TestHTTP10Response(t *testing.T) {
+	// Test: Status line matches the request version, keep-alive made explicit
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetVersion("1.0")
+	w.Write([]byte("hello"))
+	require.NoError(t, w.Finish(StatusOK))
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\nConnection: keep-alive\r\n\r\nhello", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: No informational responses for HTTP/1.0
+	w = NewWriter(&bytes.Buffer{})
+	w.SetVersion("1.0")
+	require.Error(t, w.WriteInformational(StatusContinue, nil))
+
+	// Test: Chunked body sent without the coding, ended by closing
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetVersion("1.0")
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Content-Length")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Content-Length", "11")
+	require.NoError(t, w.WriteTrailers(trailers))
+	require.NoError(t, w.Finish(StatusOK))
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nConnection: close\r\n\r\nhello world", buf.String())
+	assert.False(t, w.KeepAlive())
+}
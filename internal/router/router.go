@@ -0,0 +1,234 @@
+package router
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/CheeseFizz/httpfromtcp/internal/request"
+	"github.com/CheeseFizz/httpfromtcp/internal/response"
+	"github.com/CheeseFizz/httpfromtcp/internal/server"
+)
+
+type segmentKind int
+
+// ordered from most to least specific
+const (
+	segmentStatic segmentKind = iota
+	segmentParam
+	segmentWildcard
+)
+
+type segment struct {
+	kind  segmentKind
+	value string // literal text, or the parameter name
+}
+
+type route struct {
+	method   string // empty matches any method
+	segments []segment
+	handler  server.Handler
+}
+
+// Router dispatches requests to handlers by method and path. Patterns look like
+// "GET /users/{id}" or "/static/*path": an optional method, then a path whose
+// segments are literals, {name} parameters matching a single segment, or a
+// final *name wildcard matching the rest of the path.
+type Router struct {
+	routes     []*route
+	middleware []server.Middleware
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+func parsePattern(pattern string) (*route, error) {
+	r := route{}
+	path := pattern
+	if method, rest, found := strings.Cut(pattern, " "); found {
+		if len(method) == 0 || strings.ToUpper(method) != method {
+			return nil, fmt.Errorf("invalid method in route pattern '%s'", pattern)
+		}
+		r.method = method
+		path = rest
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("route pattern path must start with '/': '%s'", pattern)
+	}
+
+	parts := strings.Split(path[1:], "/")
+	for i, part := range parts {
+		switch {
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			if len(name) == 0 {
+				return nil, fmt.Errorf("empty parameter name in route pattern '%s'", pattern)
+			}
+			r.segments = append(r.segments, segment{kind: segmentParam, value: name})
+		case strings.HasPrefix(part, "*"):
+			name := part[1:]
+			if len(name) == 0 || i != len(parts)-1 {
+				return nil, fmt.Errorf("wildcard must be named and last in route pattern '%s'", pattern)
+			}
+			r.segments = append(r.segments, segment{kind: segmentWildcard, value: name})
+		case strings.ContainsAny(part, "{}*"):
+			return nil, fmt.Errorf("invalid segment '%s' in route pattern '%s'", part, pattern)
+		default:
+			r.segments = append(r.segments, segment{kind: segmentStatic, value: part})
+		}
+	}
+	return &r, nil
+}
+
+// Handle registers handler for pattern. It panics if the pattern is invalid,
+// since that is a programming error caught at startup.
+func (rt *Router) Handle(pattern string, handler server.Handler) {
+	r, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	r.handler = handler
+	rt.routes = append(rt.routes, r)
+}
+
+// match reports whether path matches the route and returns the extracted
+// parameters.
+func (r *route) match(path []string) (map[string]string, bool) {
+	params := make(map[string]string)
+	for i, seg := range r.segments {
+		if i >= len(path) {
+			return nil, false
+		}
+		switch seg.kind {
+		case segmentStatic:
+			if path[i] != seg.value {
+				return nil, false
+			}
+		case segmentParam:
+			if len(path[i]) == 0 {
+				return nil, false
+			}
+			params[seg.value] = path[i]
+		case segmentWildcard:
+			params[seg.value] = strings.Join(path[i:], "/")
+			return params, true
+		}
+	}
+	if len(path) != len(r.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// moreSpecific reports whether r should win over other when both match the
+// same request.
+func (r *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind < other.segments[i].kind
+		}
+	}
+	if len(r.segments) != len(other.segments) {
+		return len(r.segments) > len(other.segments)
+	}
+	return r.method != "" && other.method == ""
+}
+
+// Use adds middlewares around every request the router serves, after any added
+// before. They run before routing, so they also see requests answered with
+// 404 or 405, but not the path parameters. Like Handle, it must not be called
+// while serving.
+func (rt *Router) Use(middlewares ...server.Middleware) {
+	rt.middleware = append(rt.middleware, middlewares...)
+}
+
+// Serve dispatches req to the best matching route. It is a server.Handler.
+// Unknown paths get 404, and known paths without a route for the method get
+// 405 with an Allow header. HEAD requests fall back to GET routes; the server
+// drops the body from the response.
+func (rt *Router) Serve(w *response.Writer, req *request.Request) *server.HandlerError {
+	return server.Chain(rt.middleware...)(rt.dispatch)(w, req)
+}
+
+func (rt *Router) dispatch(w *response.Writer, req *request.Request) *server.HandlerError {
+	if req.Target == nil {
+		target, err := request.ParseTarget(req.RequestLine.Method, req.RequestLine.RequestTarget)
+		if err != nil {
+			return &server.HandlerError{StatusCode: response.StatusBadRequest, Message: "Bad Request\n"}
+		}
+		req.Target = target
+	}
+	if req.Target.Form != request.TargetFormOrigin && req.Target.Form != request.TargetFormAbsolute {
+		return &server.HandlerError{StatusCode: response.StatusNotFound, Message: "Not Found\n"}
+	}
+
+	// split before decoding so an escaped '/' stays inside its segment
+	path := strings.Split(req.Target.RawPath[1:], "/")
+	for i, seg := range path {
+		decoded, err := request.PathUnescape(seg)
+		if err != nil {
+			return &server.HandlerError{StatusCode: response.StatusBadRequest, Message: "Bad Request\n"}
+		}
+		path[i] = decoded
+	}
+
+	method := req.RequestLine.Method
+	var best *route
+	var bestParams map[string]string
+	allowed := make(map[string]bool)
+	for _, r := range rt.routes {
+		params, ok := r.match(path)
+		if !ok {
+			continue
+		}
+		if r.method != "" && r.method != method && !(method == "HEAD" && r.method == "GET") {
+			allowed[r.method] = true
+			if r.method == "GET" {
+				allowed["HEAD"] = true
+			}
+			continue
+		}
+		// an explicit HEAD route wins over an equally specific GET one
+		if best == nil || r.moreSpecific(best) || !best.moreSpecific(r) && r.method == method {
+			best = r
+			bestParams = params
+		}
+	}
+
+	if best == nil {
+		if len(allowed) == 0 {
+			return &server.HandlerError{StatusCode: response.StatusNotFound, Message: "Not Found\n"}
+		}
+		return methodNotAllowed(w, allowed)
+	}
+
+	req.Params = bestParams
+	return best.handler(w, req)
+}
+
+func methodNotAllowed(w *response.Writer, allowed map[string]bool) *server.HandlerError {
+	methods := make([]string, 0, len(allowed))
+	for method := range allowed {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	message := "Method Not Allowed\n"
+	h := response.GetDefaultHeaders(len(message))
+	h.Set("Allow", strings.Join(methods, ", "))
+
+	err := w.WriteStatusLine(response.StatusMethodNotAllowed)
+	if err != nil {
+		return &server.HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+	}
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return &server.HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+	}
+	_, err = w.WriteBody([]byte(message))
+	if err != nil {
+		return &server.HandlerError{StatusCode: response.StatusInternalServerError, Message: err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package router
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/CheeseFizz/httpfromtcp/internal/request"
+	"github.com/CheeseFizz/httpfromtcp/internal/response"
+	"github.com/CheeseFizz/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(method, target string) *request.Request {
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			HttpVersion:   "1.1",
+			Method:        method,
+			RequestTarget: target,
+		},
+	}
+}
+
+func named(name string) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.Write([]byte(name))
+		return nil
+	}
+}
+
+// serve runs the router and returns the raw response
+func serve(rt *Router, req *request.Request) string {
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	statusCode := response.StatusCode(200)
+	herr := rt.Serve(w, req)
+	if herr != nil {
+		statusCode = herr.StatusCode
+		w.Reset()
+		w.Write([]byte(herr.Message))
+	}
+	w.Finish(statusCode)
+	return buf.String()
+}
+
+func TestRouter(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /users/{id}", named("get user"))
+	rt.Handle("DELETE /users/{id}", named("delete user"))
+	rt.Handle("GET /users/me", named("me"))
+	rt.Handle("/static/*path", named("static"))
+	rt.Handle("GET /", named("root"))
+
+	// Test: Path parameter
+	req := newRequest("GET", "/users/42")
+	assert.Contains(t, serve(rt, req), "get user")
+	assert.Equal(t, "42", req.Param("id"))
+
+	// Test: Method selects the route
+	req = newRequest("DELETE", "/users/42")
+	assert.Contains(t, serve(rt, req), "delete user")
+
+	// Test: Literal segment beats parameter
+	req = newRequest("GET", "/users/me")
+	assert.Contains(t, serve(rt, req), "me")
+	assert.Equal(t, "", req.Param("id"))
+
+	// Test: Wildcard captures the rest of the path for any method
+	req = newRequest("POST", "/static/css/site.css?v=2")
+	assert.Contains(t, serve(rt, req), "static")
+	assert.Equal(t, "css/site.css", req.Param("path"))
+
+	// Test: Parameters are decoded after splitting the path
+	req = newRequest("GET", "/users/a%2Fb%20c")
+	assert.Contains(t, serve(rt, req), "get user")
+	assert.Equal(t, "a/b c", req.Param("id"))
+
+	// Test: Absolute-form target
+	req = newRequest("GET", "http://localhost:42069/users/7")
+	assert.Contains(t, serve(rt, req), "get user")
+	assert.Equal(t, "7", req.Param("id"))
+
+	// Test: Root
+	req = newRequest("GET", "/")
+	assert.Contains(t, serve(rt, req), "root")
+
+	// Test: HEAD falls back to the GET route
+	req = newRequest("HEAD", "/users/42")
+	assert.Contains(t, serve(rt, req), "get user")
+	assert.Equal(t, "42", req.Param("id"))
+
+	// Test: Explicit HEAD route wins over GET
+	headRouter := New()
+	headRouter.Handle("GET /ping", named("get ping"))
+	headRouter.Handle("HEAD /ping", named("head ping"))
+	assert.Contains(t, serve(headRouter, newRequest("HEAD", "/ping")), "head ping")
+	assert.Contains(t, serve(headRouter, newRequest("GET", "/ping")), "get ping")
+
+	// Test: Unknown path
+	out := serve(rt, newRequest("GET", "/nope"))
+	assert.Contains(t, out, "HTTP/1.1 404 Not Found\r\n")
+
+	// Test: Empty parameter does not match
+	out = serve(rt, newRequest("GET", "/users/"))
+	assert.Contains(t, out, "HTTP/1.1 404 Not Found\r\n")
+
+	// Test: Known path, wrong method
+	out = serve(rt, newRequest("PUT", "/users/42"))
+	assert.Contains(t, out, "HTTP/1.1 405 Method Not Allowed\r\n")
+	assert.Contains(t, out, "Allow: DELETE, GET, HEAD\r\n")
+
+	// Test: Router middleware also sees unrouted requests
+	seen := []string{}
+	rt.Use(func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) *server.HandlerError {
+			seen = append(seen, req.RequestLine.RequestTarget)
+			return next(w, req)
+		}
+	})
+	assert.Contains(t, serve(rt, newRequest("GET", "/users/1")), "get user")
+	assert.Contains(t, serve(rt, newRequest("GET", "/nope")), "HTTP/1.1 404 Not Found\r\n")
+	assert.Equal(t, []string{"/users/1", "/nope"}, seen)
+
+	// Test: Invalid patterns
+	require.Panics(t, func() { rt.Handle("users", named("bad")) })
+	require.Panics(t, func() { rt.Handle("/files/*path/more", named("bad")) })
+	require.Panics(t, func() { rt.Handle("/users/{}", named("bad")) })
+	require.Panics(t, func() { rt.Handle("get /users", named("bad")) })
+}
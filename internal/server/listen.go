@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listen opens the listener described by config, falling back to the loopback
+// address on port when no address is set.
+func listen(port int, config Config) (net.Listener, error) {
+	network := config.Network
+	if network == "" {
+		network = "tcp"
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		address := config.Address
+		if address == "" {
+			host := "127.0.0.1"
+			if network == "tcp6" {
+				host = "::1"
+			}
+			address = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		return net.Listen(network, address)
+	case "unix":
+		return listenUnix(config)
+	default:
+		return nil, fmt.Errorf("unsupported network: %s", network)
+	}
+}
+
+// listenUnix listens on a Unix socket, first removing a stale socket file left
+// behind by a process that did not shut down cleanly. The file is removed again
+// when the listener is closed.
+func listenUnix(config Config) (net.Listener, error) {
+	path := config.Address
+	if path == "" {
+		return nil, fmt.Errorf("unix socket path not set")
+	}
+
+	info, err := os.Lstat(path)
+	switch {
+	case err == nil:
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		// a socket nobody answers on is stale; a live one is left alone and
+		// the listen below fails
+		conn, derr := net.Dial("unix", path)
+		if derr == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.SocketMode != 0 {
+		err = os.Chmod(path, config.SocketMode)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	return listener, nil
+}
@@ -1,17 +1,39 @@
 package server
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"os"
+	"runtime/debug"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/CheeseFizz/httpfromtcp/internal/request"
 	"github.com/CheeseFizz/httpfromtcp/internal/response"
 )
 
+const (
+	DefaultIdleTimeout       = 2 * time.Minute
+	DefaultReadHeaderTimeout = 10 * time.Second
+)
+
+// maxDiscardBytes bounds how much unread streamed body is read and dropped to
+// keep a connection alive; past that the connection is closed instead.
+const maxDiscardBytes = 256 << 10
+
+// lingerTimeout bounds how long a closing connection keeps reading so the peer
+// can receive the last response.
+const lingerTimeout = 500 * time.Millisecond
+
+// shutdownPollInterval is how often Shutdown checks for connections that have
+// gone idle.
+const shutdownPollInterval = 10 * time.Millisecond
+
 type ServerState int
 
 const (
@@ -20,30 +42,119 @@ const (
 	serverStateStopped
 )
 
+type connState int
+
+const (
+	// waiting for the next request
+	connStateIdle connState = iota
+	// reading a request or running its handler
+	connStateActive
+)
+
 type HandlerError struct {
 	StatusCode response.StatusCode
 	Message    string
 }
 
-func (herr *HandlerError) WriteError(conn net.Conn) error {
-	h := response.GetDefaultHeaders(len(herr.Message))
-	response.WriteStatusLine(conn, herr.StatusCode)
-	response.WriteHeaders(conn, h)
-	_, err := fmt.Fprintf(conn, "%s\r\n", herr.Message)
-	if err != nil {
-		return err
+// Handler writes the response to req through w. Returning a non-nil
+// *HandlerError before anything has been written replaces the response with
+// that status and message; whatever the handler leaves unwritten is filled in
+// with defaults. HEAD requests can be handled like GET since the body is
+// dropped from the response.
+type Handler func(w *response.Writer, req *request.Request) *HandlerError
+
+// Middleware wraps a Handler to run code before or after it, or instead of it.
+type Middleware func(next Handler) Handler
+
+// Chain composes middlewares into one. The first one is outermost, so it sees
+// the request first and the response last.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
 	}
-	return nil
 }
 
-type Handler func(w io.Writer, req *request.Request) *HandlerError
+// Config holds the optional server settings. The zero value is usable.
+type Config struct {
+	// IdleTimeout bounds how long a keep-alive connection may wait for its next
+	// request. Zero means DefaultIdleTimeout.
+	IdleTimeout time.Duration
+	// ReadHeaderTimeout bounds the time from the first byte of a request until
+	// its headers are read; expiring answers 408. Zero means
+	// DefaultReadHeaderTimeout.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds the time to read a whole request, body included. Zero
+	// means no limit.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds the time to write a response. Zero means no limit.
+	WriteTimeout time.Duration
+	// Limits bounds the size of each request. The zero value uses
+	// request.DefaultLimits.
+	Limits request.Limits
+	// StreamRequestBody hands requests to the handler as soon as their headers
+	// are read, with the body left on the connection for req.BodyReader. Any
+	// part the handler leaves unread is discarded afterward. A client sending
+	// "Expect: 100-continue" is told to go on only once the handler reads the
+	// body.
+	StreamRequestBody bool
+	// MaxRequestsPerConn closes a connection after it has served this many
+	// requests. Zero means no limit.
+	MaxRequestsPerConn int
+	// AllowLeadingCRLF skips empty lines before a request line instead of
+	// answering 400, see request.Reader.
+	AllowLeadingCRLF bool
+	// PanicHook, if set, is called with the value and stack of any panic
+	// recovered while serving a connection, after it is logged. req is nil if
+	// the panic happened outside a handler.
+	PanicHook func(req *request.Request, v any, stack []byte)
+
+	// Network is "tcp", "tcp4", "tcp6" or "unix". Empty means "tcp".
+	Network string
+	// Address is the address to listen on, or the socket path for "unix". Empty
+	// means the loopback address, [::1] for "tcp6" and 127.0.0.1 otherwise, on
+	// the port passed to ServeConfig.
+	Address string
+	// SocketMode sets the permissions of a Unix socket file. Zero keeps the
+	// default from the process umask.
+	SocketMode os.FileMode
+}
 
 type Server struct {
 	Port     int
 	Handler  Handler
+	Config   Config
 	state    ServerState
 	listener net.Listener
 	closed   atomic.Bool
+
+	mu         sync.Mutex
+	conns      map[net.Conn]connState
+	middleware []Middleware
+}
+
+// Use adds middlewares around Handler, after any added before. It applies to
+// requests read from then on, including on open connections.
+func (s *Server) Use(middlewares ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.middleware = append(s.middleware, middlewares...)
+}
+
+// handler returns Handler wrapped in the middlewares added with Use.
+func (s *Server) handler() Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return Chain(s.middleware...)(s.Handler)
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
 }
 
 func (s *Server) Close() error {
@@ -55,53 +166,399 @@ func (s *Server) Close() error {
 	return nil
 }
 
+// Shutdown stops accepting connections, closes idle ones, and waits for active
+// ones to finish their current response. If ctx ends first the remaining
+// connections are closed forcibly; the number cut off that way is returned
+// along with the context's error.
+func (s *Server) Shutdown(ctx context.Context) (int, error) {
+	s.closed.Store(true)
+	s.listener.Close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeConns(false) == 0 {
+			s.state = serverStateStopped
+			return 0, nil
+		}
+		select {
+		case <-ctx.Done():
+			cut := s.closeConns(true)
+			s.state = serverStateStopped
+			return cut, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeConns closes idle connections, or all of them if force is set, and
+// returns how many were active.
+func (s *Server) closeConns(force bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remaining := 0
+	for conn, state := range s.conns {
+		if state == connStateIdle || force {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+		if state == connStateActive {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+func (s *Server) trackConn(conn net.Conn, state connState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]connState)
+	}
+	s.conns[conn] = state
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.Config.IdleTimeout > 0 {
+		return s.Config.IdleTimeout
+	}
+	return DefaultIdleTimeout
+}
+
+// readHeaderTimeout is the header timeout, capped by ReadTimeout since the
+// headers are part of the request.
+func (s *Server) readHeaderTimeout() time.Duration {
+	timeout := DefaultReadHeaderTimeout
+	if s.Config.ReadHeaderTimeout > 0 {
+		timeout = s.Config.ReadHeaderTimeout
+	}
+	if s.Config.ReadTimeout > 0 && s.Config.ReadTimeout < timeout {
+		timeout = s.Config.ReadTimeout
+	}
+	return timeout
+}
+
+// keepAlive reports whether the connection should stay open after responding
+// to req, the served'th request on it.
+func (s *Server) keepAlive(req *request.Request, served int) bool {
+	if s.closed.Load() {
+		return false
+	}
+	if s.Config.MaxRequestsPerConn > 0 && served >= s.Config.MaxRequestsPerConn {
+		return false
+	}
+	return req.KeepAlive()
+}
+
+// closeConn closes the write side first and discards input for a short while
+// before closing fully. Closing with unread input makes the kernel send a reset,
+// which can destroy a response the client has not read yet.
+func closeConn(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		if cw.CloseWrite() == nil {
+			conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+			io.Copy(io.Discard, conn)
+		}
+	}
+	return conn.Close()
+}
+
 func (s *Server) handle(conn net.Conn) {
+	s.trackConn(conn, connStateIdle)
+	defer func() {
+		// a panic only takes down its own connection
+		if v := recover(); v != nil {
+			s.reportPanic(conn, nil, v)
+		}
+		s.untrackConn(conn)
+		err := closeConn(conn)
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Println(err)
+		}
+	}()
 
 	log.Printf("Connection to %s", conn.RemoteAddr().String())
 
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		log.Println(err)
-	}
-	log.Printf("%s requested: %s", conn.RemoteAddr().String(), req.RequestLine.RequestTarget)
-	buf := bytes.NewBuffer([]byte(""))
-	herr := s.Handler(buf, req)
-	if (herr.StatusCode < 200) || (herr.StatusCode >= 300) {
-		err = herr.WriteError(conn)
+	reader := request.NewReader(conn)
+	reader.Limits = s.Config.Limits
+	reader.StreamBody = s.Config.StreamRequestBody
+	reader.AllowLeadingCRLF = s.Config.AllowLeadingCRLF
+	for served := 1; ; served++ {
+		if s.closed.Load() {
+			return
+		}
+		s.trackConn(conn, connStateIdle)
+
+		// wait for the next request; the read deadlines only start once it arrives
+		err := conn.SetReadDeadline(deadline(time.Now(), s.idleTimeout()))
 		if err != nil {
 			log.Println(err)
+			return
 		}
-	} else {
-		h := response.GetDefaultHeaders(len(buf.Bytes()))
-		err = response.WriteStatusLine(conn, 200)
+		err = reader.WaitForRequest()
 		if err != nil {
-			log.Println(err)
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !isTimeout(err) {
+				log.Printf("%s: %v", conn.RemoteAddr().String(), err)
+			}
+			return
 		}
-		err = response.WriteHeaders(conn, h)
+		s.trackConn(conn, connStateActive)
+
+		w := response.NewWriter(conn)
+		req, err := s.readRequest(conn, reader, w)
 		if err != nil {
-			log.Println(err)
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("%s: %v", conn.RemoteAddr().String(), err)
+			if statusCode, ok := errorStatus(err); ok {
+				werr := conn.SetWriteDeadline(deadline(time.Now(), s.Config.WriteTimeout))
+				if werr == nil {
+					werr = writeError(conn, statusCode)
+				}
+				if werr != nil {
+					log.Println(werr)
+				}
+			}
+			return
 		}
-		_, err = fmt.Fprintf(conn, "%s\r\n", buf.String())
+
+		log.Printf("%s requested: %s", conn.RemoteAddr().String(), req.RequestLine.RequestTarget)
+		err = conn.SetWriteDeadline(deadline(time.Now(), s.Config.WriteTimeout))
 		if err != nil {
 			log.Println(err)
+			return
 		}
+		keepAlive, err := s.respond(conn, w, req, s.keepAlive(req, served))
+		if err != nil {
+			log.Printf("%s: %v", conn.RemoteAddr().String(), err)
+			return
+		}
+
+		// the next request starts after whatever body the handler left unread
+		if keepAlive && reader.StreamBody {
+			err = reader.Discard(req, maxDiscardBytes)
+			if err != nil {
+				log.Printf("%s: dropping connection: %v", conn.RemoteAddr().String(), err)
+				return
+			}
+		}
+
+		if !keepAlive {
+			return
+		}
+	}
+}
+
+// readRequest reads a request whose first bytes have arrived, holding its
+// headers to the header timeout and the whole request to ReadTimeout. A 100
+// Continue is sent through w, the writer for the request's response.
+func (s *Server) readRequest(conn net.Conn, reader *request.Reader, w *response.Writer) (*request.Request, error) {
+	start := time.Now()
+	headersDone := false
+
+	err := conn.SetReadDeadline(deadline(start, s.readHeaderTimeout()))
+	if err != nil {
+		return nil, err
+	}
+	reader.OnHeaders = func(req *request.Request) error {
+		headersDone = true
+		return conn.SetReadDeadline(deadline(start, s.Config.ReadTimeout))
+	}
+	reader.OnContinue = func(req *request.Request) error {
+		// once the handler has started its final response a 100 would end up
+		// inside it; the client sends the body anyway after waiting a while
+		if w.StatusWritten() {
+			return nil
+		}
+		err := conn.SetWriteDeadline(deadline(time.Now(), s.Config.WriteTimeout))
+		if err != nil {
+			return err
+		}
+		return w.WriteInformational(response.StatusContinue, nil)
+	}
+
+	req, err := reader.ReadRequest()
+	if isTimeout(err) {
+		if headersDone {
+			return nil, fmt.Errorf("timed out reading request body: %w", err)
+		}
+		return nil, fmt.Errorf("timed out reading request headers: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// a streamed body is still being read, so ReadTimeout stays in force
+	if !reader.StreamBody {
+		err = conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+func isTimeout(err error) bool {
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
+// deadline returns start+timeout, or no deadline for a zero timeout.
+func deadline(start time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return start.Add(timeout)
+}
+
+// errorStatus maps an error from reading a request to the status code sent
+// back to the client. It reports false for connection errors, where there is no
+// one left to answer.
+func errorStatus(err error) (response.StatusCode, bool) {
+	var nerr net.Error
+	switch {
+	case isTimeout(err):
+		return response.StatusRequestTimeout, true
+	case errors.Is(err, net.ErrClosed), errors.As(err, &nerr):
+		return 0, false
+	case errors.Is(err, request.ErrRequestLineTooLong):
+		return response.StatusURITooLong, true
+	case errors.Is(err, request.ErrHeadersTooLarge):
+		return response.StatusRequestHeaderFieldsTooLarge, true
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.StatusContentTooLarge, true
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return response.StatusHTTPVersionNotSupported, true
+	case errors.Is(err, request.ErrUnsupportedTransferCoding):
+		return response.StatusNotImplemented, true
+	case errors.Is(err, request.ErrExpectationFailed):
+		return response.StatusExpectationFailed, true
 	}
+	// anything else is a malformed request: ErrBadRequestLine, ErrBadHeader,
+	// ErrBadChunk, ErrInvalidTarget, the framing errors or a truncated request
+	return response.StatusBadRequest, true
+}
 
-	err = conn.Close()
+// writeError sends a short error response for a request that could not be
+// read. The body is just the reason phrase; the details stay in the log. The
+// connection cannot be reused afterward since the framing of the rest of the
+// stream is unknown.
+func writeError(conn net.Conn, statusCode response.StatusCode) error {
+	w := response.NewWriter(conn)
+	w.SetKeepAlive(false)
+	_, err := fmt.Fprintf(w, "%s\n", response.StatusText(statusCode))
 	if err != nil {
-		log.Println(err)
+		return err
 	}
+	return w.Finish(statusCode)
+}
 
+// respond runs the handler for req and finishes the response. It reports
+// whether the connection can be kept open afterward.
+func (s *Server) respond(conn net.Conn, w *response.Writer, req *request.Request, keepAlive bool) (bool, error) {
+	w.SetKeepAlive(keepAlive)
+	w.SetHead(req.RequestLine.Method == "HEAD")
+	w.SetVersion(req.RequestLine.HttpVersion)
+	defer func() {
+		if req.MultipartForm != nil {
+			err := req.MultipartForm.RemoveAll()
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+
+	statusCode := response.StatusOK
+	herr, panicked := s.runHandler(conn, w, req)
+	if panicked && w.HeadersWritten() {
+		// finishing would make a cut-off body look complete; closing the
+		// connection tells the client it is not
+		return false, nil
+	}
+	if herr != nil {
+		if w.HeadersWritten() {
+			log.Printf("handler error after response was started: %d %s", herr.StatusCode, herr.Message)
+		} else if !herr.StatusCode.Valid() || herr.StatusCode.Informational() {
+			// there is no status line to send for it, and a 1xx would leave the
+			// client waiting for the final response
+			log.Printf("handler error with invalid status: %d %s", herr.StatusCode, herr.Message)
+			w.Reset()
+			statusCode = response.StatusInternalServerError
+			w.Write([]byte(response.StatusText(statusCode) + "\n"))
+		} else {
+			w.Reset()
+			statusCode = herr.StatusCode
+			w.Write([]byte(herr.Message))
+		}
+	}
+
+	// the body was never asked for, so the client may or may not send it
+	// now; the connection cannot be reused either way
+	if req.ExpectsContinue() {
+		w.SetKeepAlive(false)
+	}
+
+	err := w.Finish(statusCode)
+	return w.KeepAlive(), err
+}
+
+// runHandler runs the handler for req, recovering a panic into a 500 error.
+func (s *Server) runHandler(conn net.Conn, w *response.Writer, req *request.Request) (herr *HandlerError, panicked bool) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		s.reportPanic(conn, req, v)
+		herr = &HandlerError{
+			StatusCode: response.StatusInternalServerError,
+			Message:    "Internal Server Error\n",
+		}
+		panicked = true
+	}()
+	return s.handler()(w, req), false
+}
+
+// reportPanic logs a recovered panic with its stack and passes it to the
+// PanicHook. req is nil for a panic outside a handler.
+func (s *Server) reportPanic(conn net.Conn, req *request.Request, v any) {
+	stack := debug.Stack()
+	if req != nil {
+		line := req.RequestLine
+		log.Printf("%s: panic serving %s %s HTTP/%s: %v\n%s", conn.RemoteAddr().String(), line.Method, line.RequestTarget, line.HttpVersion, v, stack)
+	} else {
+		log.Printf("%s: panic: %v\n%s", conn.RemoteAddr().String(), v, stack)
+	}
+	if s.Config.PanicHook != nil {
+		s.Config.PanicHook(req, v, stack)
+	}
 }
 
 func (s *Server) listen() {
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil && !s.closed.Load() {
+			// a listener passed to ServeListener may be closed by its owner
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			log.Println(err)
 			continue
 		}
 		if s.closed.Load() {
+			if conn != nil {
+				conn.Close()
+			}
 			return
 		}
 		go s.handle(conn)
@@ -109,17 +566,34 @@ func (s *Server) listen() {
 }
 
 func Serve(port int, handler Handler) (server *Server, err error) {
-	server = &Server{
-		state:   serverStateInitializing,
-		Port:    port,
-		Handler: handler,
-	}
+	return ServeConfig(port, handler, Config{})
+}
 
-	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+func ServeConfig(port int, handler Handler, config Config) (server *Server, err error) {
+	listener, err := listen(port, config)
 	if err != nil {
-		return server, err
+		return &Server{Port: port, Handler: handler, Config: config}, err
+	}
+	return ServeListenerConfig(listener, handler, config)
+}
+
+// ServeListener serves connections accepted from an already open listener,
+// such as one handed over by a supervisor or opened by a test. The listener is
+// closed when the server is.
+func ServeListener(listener net.Listener, handler Handler) (server *Server, err error) {
+	return ServeListenerConfig(listener, handler, Config{})
+}
+
+func ServeListenerConfig(listener net.Listener, handler Handler, config Config) (server *Server, err error) {
+	server = &Server{
+		state:    serverStateInitializing,
+		Handler:  handler,
+		Config:   config,
+		listener: listener,
+	}
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		server.Port = addr.Port
 	}
-	server.listener = listener
 
 	go server.listen()
 
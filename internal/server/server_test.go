@@ -0,0 +1,587 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CheeseFizz/httpfromtcp/internal/request"
+	"github.com/CheeseFizz/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoTarget(w *response.Writer, req *request.Request) *HandlerError {
+	w.Write([]byte(req.RequestLine.RequestTarget))
+	return nil
+}
+
+// startServer serves handler on a random local port
+func startServer(t *testing.T, handler Handler, config Config) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s, err := ServeListenerConfig(listener, handler, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// roundTrip writes raw to a new connection and returns everything the server
+// sends before closing it
+func roundTrip(t *testing.T, s *Server, raw string) string {
+	conn, err := net.Dial(s.Addr().Network(), s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestKeepAlive(t *testing.T) {
+	// Test: Pipelined requests answered in order on one connection
+	s := startServer(t, echoTarget, Config{})
+	out := roundTrip(t, s, "GET /one HTTP/1.1\r\n\r\n"+
+		"GET /two HTTP/1.1\r\n\r\n"+
+		"GET /three HTTP/1.1\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, 3, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, 1, strings.Count(out, "Connection: close\r\n"))
+	assert.Contains(t, out, "\r\n\r\n/oneHTTP/1.1")
+	assert.Contains(t, out, "\r\n\r\n/twoHTTP/1.1")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n/three"))
+
+	// Test: Max requests per connection
+	s = startServer(t, echoTarget, Config{MaxRequestsPerConn: 1})
+	out = roundTrip(t, s, "GET /one HTTP/1.1\r\n\r\nGET /two HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out, "Connection: close\r\n")
+	assert.NotContains(t, out, "/two")
+
+	// Test: Body shorter than its declared length closes the connection
+	s = startServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(10))
+		w.Write([]byte("half"))
+		return nil
+	}, Config{})
+	out = roundTrip(t, s, "GET /one HTTP/1.1\r\n\r\nGET /two HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhalf"))
+
+	// Test: Idle timeout closes the connection
+	s = startServer(t, echoTarget, Config{IdleTimeout: 50 * time.Millisecond})
+	out = roundTrip(t, s, "GET /one HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out, "/one")
+}
+
+func TestBadFraming(t *testing.T) {
+	// Test: Conflicting lengths get a 400 and the connection is closed
+	s := startServer(t, echoTarget, Config{})
+	out := roundTrip(t, s, "POST /one HTTP/1.1\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\nab"+
+		"GET /two HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out, "HTTP/1.1 400 Bad Request\r\n")
+	assert.Contains(t, out, "Connection: close\r\n")
+	assert.NotContains(t, out, "/two")
+
+	// Test: Folded Transfer-Encoding is not taken as framing
+	out = roundTrip(t, s, "POST /one HTTP/1.1\r\nHost: a\r\n Transfer-Encoding: chunked\r\n\r\n"+
+		"3\r\nabc\r\n0\r\n\r\nGET /smuggled HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out, "HTTP/1.1 400 Bad Request\r\n")
+	assert.NotContains(t, out, "/smuggled")
+}
+
+func TestShutdown(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	s := startServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		if req.RequestLine.RequestTarget == "/busy" {
+			close(started)
+			<-release
+		}
+		w.Write([]byte("done"))
+		return nil
+	}, Config{})
+
+	idle, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer idle.Close()
+	busy, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer busy.Close()
+	partial, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer partial.Close()
+	_, err = busy.Write([]byte("GET /busy HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = partial.Write([]byte("GET /partial HTTP/1.1\r\n"))
+	require.NoError(t, err)
+	<-started
+	time.Sleep(50 * time.Millisecond)
+
+	// Test: Active handler and a request still being sent are drained
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		partial.Write([]byte("\r\n"))
+		close(release)
+	}()
+	cut, err := s.Shutdown(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, cut)
+	out, err := io.ReadAll(busy)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "done")
+	out, err = io.ReadAll(partial)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "done")
+
+	// Test: Idle connection is closed
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := idle.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Deadline cuts active connections
+	started = make(chan struct{})
+	s = startServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		close(started)
+		time.Sleep(time.Second)
+		return nil
+	}, Config{})
+	busy, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer busy.Close()
+	_, err = busy.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cut, err = s.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, cut)
+}
+
+func TestServeDefaultAddress(t *testing.T) {
+	// Test: tcp listens on IPv4 loopback
+	s, err := ServeConfig(0, echoTarget, Config{})
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, "127.0.0.1", s.Addr().(*net.TCPAddr).IP.String())
+
+	// Test: tcp6 listens on IPv6 loopback
+	probe, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	probe.Close()
+	s, err = ServeConfig(0, echoTarget, Config{Network: "tcp6"})
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, "::1", s.Addr().(*net.TCPAddr).IP.String())
+	out := roundTrip(t, s, "GET /six HTTP/1.1\r\nConnection: close\r\n\r\n")
+	assert.Contains(t, out, "/six")
+}
+
+func TestServeUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.sock")
+
+	// Test: Stale socket file is replaced
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	s, err := ServeConfig(0, echoTarget, Config{Network: "unix", Address: path, SocketMode: 0600})
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	out := roundTrip(t, s, "GET /unix HTTP/1.1\r\nConnection: close\r\n\r\n")
+	assert.Contains(t, out, "/unix")
+
+	// Test: Live socket is not taken over
+	_, err = ServeConfig(0, echoTarget, Config{Network: "unix", Address: path})
+	require.Error(t, err)
+
+	// Test: Socket file removed on close
+	s.Close()
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Test: Unknown network
+	_, err = ServeConfig(0, echoTarget, Config{Network: "udp"})
+	require.Error(t, err)
+}
+
+// countingListener counts calls to Accept
+type countingListener struct {
+	net.Listener
+	accepts atomic.Int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	l.accepts.Add(1)
+	return l.Listener.Accept()
+}
+
+func TestServeListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := &countingListener{Listener: inner}
+	s, err := ServeListener(listener, echoTarget)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	out := roundTrip(t, s, "GET /one HTTP/1.1\r\nConnection: close\r\n\r\n")
+	assert.Contains(t, out, "/one")
+
+	// Test: Listener closed by its owner stops accepting
+	listener.Close()
+	time.Sleep(20 * time.Millisecond)
+	accepts := listener.accepts.Load()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, accepts, listener.accepts.Load())
+}
+
+func TestTimeouts(t *testing.T) {
+	s := startServer(t, echoTarget, Config{
+		IdleTimeout:       100 * time.Millisecond,
+		ReadHeaderTimeout: 100 * time.Millisecond,
+		ReadTimeout:       200 * time.Millisecond,
+	})
+
+	// Test: Idle connection closed without a response
+	out := roundTrip(t, s, "")
+	assert.Equal(t, "", out)
+
+	// Test: Slow headers get a 408
+	out = roundTrip(t, s, "GET /slow HTTP/1.1\r\nHost: loc")
+	assert.Contains(t, out, "HTTP/1.1 408 Request Timeout\r\n")
+	assert.Contains(t, out, "Connection: close\r\n")
+
+	// Test: Slow body gets a 408
+	out = roundTrip(t, s, "POST /slow HTTP/1.1\r\nContent-Length: 10\r\n\r\nabc")
+	assert.Contains(t, out, "HTTP/1.1 408 Request Timeout\r\n")
+
+	// Test: Idle time between requests does not count against the next one
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /one HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(80 * time.Millisecond)
+	_, err = conn.Write([]byte("GET /two HTTP/1.1\r\n"))
+	require.NoError(t, err)
+	time.Sleep(80 * time.Millisecond)
+	_, err = conn.Write([]byte("Connection: close\r\n\r\n"))
+	require.NoError(t, err)
+	b, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "/one")
+	assert.Contains(t, string(b), "/two")
+}
+
+func TestLimits(t *testing.T) {
+	s := startServer(t, echoTarget, Config{Limits: request.Limits{
+		MaxRequestLineBytes: 32,
+		MaxHeaderCount:      2,
+		MaxBodyBytes:        4,
+	}})
+
+	// Test: Long request line
+	out := roundTrip(t, s, "GET /"+strings.Repeat("a", 40)+" HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out, "HTTP/1.1 414 URI Too Long\r\n")
+
+	// Test: Too many headers
+	out = roundTrip(t, s, "GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n")
+	assert.Contains(t, out, "HTTP/1.1 431 Request Header Fields Too Large\r\n")
+
+	// Test: Body too large
+	out = roundTrip(t, s, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+	assert.Contains(t, out, "HTTP/1.1 413 Content Too Large\r\n")
+}
+
+func TestStreamRequestBody(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		// read only the first few bytes of the body
+		buf := make([]byte, 3)
+		n, _ := io.ReadFull(req.BodyReader, buf)
+		w.Write(buf[:n])
+		return nil
+	}, Config{StreamRequestBody: true})
+
+	// Test: Unread body does not leak into the next request
+	out := roundTrip(t, s, "POST /one HTTP/1.1\r\nContent-Length: 10\r\n\r\n0123456789"+
+		"POST /two HTTP/1.1\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n5\r\nabcde\r\n0\r\n\r\n")
+	assert.Equal(t, 2, strings.Count(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "\r\n\r\n012HTTP/1.1")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nabc"))
+}
+
+func TestExpectContinue(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		if req.RequestLine.RequestTarget == "/reject" {
+			return &HandlerError{StatusCode: 401, Message: "no\n"}
+		}
+		if req.RequestLine.RequestTarget == "/stream" {
+			w.WriteStatusLine(response.StatusOK)
+			h := response.GetDefaultHeaders(0)
+			h.Del("Content-Length")
+			h.Set("Transfer-Encoding", "chunked")
+			w.WriteHeaders(h)
+		}
+		body, err := io.ReadAll(req.BodyReader)
+		if err != nil {
+			return &HandlerError{StatusCode: 500, Message: err.Error()}
+		}
+		w.Write(body)
+		return nil
+	}, Config{StreamRequestBody: true, Limits: request.Limits{MaxBodyBytes: 100}})
+
+	// Test: 100 Continue sent when the handler reads the body
+	conn, err := net.Dial(s.Addr().Network(), s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("POST /upload HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 5\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	interim := "HTTP/1.1 100 Continue\r\n\r\n"
+	buf := make([]byte, len(interim))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, interim, string(buf))
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nhello"))
+
+	// Test: No 100 Continue once the handler has started its response
+	conn, err = net.Dial(s.Addr().Network(), s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("POST /stream HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 3\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	head := []byte{}
+	for !strings.HasSuffix(string(head), "\r\n\r\n") {
+		b := make([]byte, 1)
+		_, err = conn.Read(b)
+		require.NoError(t, err)
+		head = append(head, b...)
+	}
+	assert.True(t, strings.HasPrefix(string(head), "HTTP/1.1 200 OK\r\n"))
+	_, err = conn.Write([]byte("abc"))
+	require.NoError(t, err)
+	out, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "3\r\nabc\r\n0\r\n\r\n", string(out))
+
+	// Test: Body never read gets no 100 Continue and the connection is closed
+	out2 := roundTrip(t, s, "POST /reject HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out2, "HTTP/1.1 401 Unauthorized\r\n"))
+	assert.NotContains(t, out2, "100 Continue")
+	assert.Contains(t, out2, "Connection: close\r\n")
+
+	// Test: Oversize body refused before continuing
+	out2 = roundTrip(t, s, "POST /upload HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 1000\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out2, "HTTP/1.1 413 Content Too Large\r\n"))
+	assert.NotContains(t, out2, "100 Continue")
+
+	// Test: Unknown expectation refused
+	out2 = roundTrip(t, s, "POST /upload HTTP/1.1\r\nExpect: something-else\r\nContent-Length: 5\r\n\r\nhello")
+	assert.True(t, strings.HasPrefix(out2, "HTTP/1.1 417 Expectation Failed\r\n"))
+}
+
+func TestHead(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		if req.RequestLine.RequestTarget == "/empty" {
+			return &HandlerError{StatusCode: 204}
+		}
+		w.Write([]byte("hello"))
+		return nil
+	}, Config{})
+
+	// Test: HEAD gets GET's headers without a body, on a reusable connection
+	out := roundTrip(t, s, "HEAD / HTTP/1.1\r\n\r\n"+
+		"HEAD /empty HTTP/1.1\r\n\r\n"+
+		"GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\n"+
+		"HTTP/1.1 204 No Content\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\nhello", out)
+}
+
+func TestMiddleware(t *testing.T) {
+	order := []string{}
+	record := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *response.Writer, req *request.Request) *HandlerError {
+				order = append(order, name+" in")
+				herr := next(w, req)
+				order = append(order, name+" out")
+				return herr
+			}
+		}
+	}
+
+	// Test: Chain runs the first middleware outermost
+	handler := Chain(record("a"), record("b"))(func(w *response.Writer, req *request.Request) *HandlerError {
+		order = append(order, "handler")
+		return nil
+	})
+	handler(response.NewWriter(io.Discard), &request.Request{})
+	assert.Equal(t, []string{"a in", "b in", "handler", "b out", "a out"}, order)
+
+	// Test: Use applies to requests on a running server
+	s := startServer(t, echoTarget, Config{})
+	s.Use(func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) *HandlerError {
+			if req.RequestLine.RequestTarget == "/secret" {
+				return &HandlerError{StatusCode: 403, Message: "no\n"}
+			}
+			w.Header().Set("X-Wrapped", "yes")
+			return next(w, req)
+		}
+	})
+	out := roundTrip(t, s, "GET /secret HTTP/1.1\r\n\r\nGET /open HTTP/1.1\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden\r\n"))
+	assert.Contains(t, out, "X-Wrapped: yes\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n/open"))
+}
+
+func TestInvalidHeaders(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		w.WriteStatusLine(response.StatusFound)
+		h := response.GetDefaultHeaders(0)
+		h.Set("Location", "/next\r\nSet-Cookie: evil=1")
+		err := w.WriteHeaders(h)
+		if err != nil {
+			return &HandlerError{StatusCode: response.StatusInternalServerError, Message: "bad header\n"}
+		}
+		return nil
+	}, Config{})
+
+	// Test: Refused headers leave room for the error response
+	out := roundTrip(t, s, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"), out)
+	assert.NotContains(t, out, "302")
+	assert.NotContains(t, out, "evil")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nbad header\n"))
+}
+
+func TestHandlerErrorStatus(t *testing.T) {
+	for _, statusCode := range []response.StatusCode{0, 100, 99, 600, 999} {
+		s := startServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+			return &HandlerError{StatusCode: statusCode, Message: "oops\n"}
+		}, Config{})
+
+		// Test: Invalid or informational status becomes a 500
+		out := roundTrip(t, s, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"), "%d: %q", statusCode, out)
+		assert.True(t, strings.HasSuffix(out, "\r\n\r\nInternal Server Error\n"))
+	}
+
+	// Test: Unregistered status in range is sent as is
+	s := startServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		return &HandlerError{StatusCode: 299, Message: "odd\n"}
+	}, Config{})
+	out := roundTrip(t, s, "GET / HTTP/1.1\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 299 \r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nodd\n"))
+}
+
+func TestPanicRecovery(t *testing.T) {
+	panics := make(chan string, 10)
+	s := startServer(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		switch req.RequestLine.RequestTarget {
+		case "/early":
+			panic("early")
+		case "/late":
+			w.WriteStatusLine(200)
+			w.WriteHeaders(response.GetDefaultHeaders(10))
+			w.Write([]byte("half"))
+			panic("late")
+		}
+		return echoTarget(w, req)
+	}, Config{PanicHook: func(req *request.Request, v any, stack []byte) {
+		panics <- fmt.Sprintf("%s %v %t", req.RequestLine.RequestTarget, v, len(stack) > 0)
+	}})
+
+	// Test: Panic before the response gets a 500 and the connection lives on
+	out := roundTrip(t, s, "GET /early HTTP/1.1\r\n\r\nGET /after HTTP/1.1\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n/after"))
+	assert.Equal(t, "/early early true", <-panics)
+
+	// Test: Panic mid-response cuts the connection without finishing it
+	out = roundTrip(t, s, "GET /late HTTP/1.1\r\n\r\nGET /after HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhalf"))
+	assert.NotContains(t, out, "/after")
+	assert.Equal(t, "/late late true", <-panics)
+}
+
+func TestMalformedRequests(t *testing.T) {
+	s := startServer(t, echoTarget, Config{})
+
+	for _, tc := range []struct {
+		name   string
+		raw    string
+		status string
+	}{
+		{"bad request line", "GET /\r\n\r\n", "400 Bad Request"},
+		{"bad header", "GET / HTTP/1.1\r\nHost localhost\r\n\r\n", "400 Bad Request"},
+		{"bad chunk", "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", "400 Bad Request"},
+		{"blank transfer coding", "POST / HTTP/1.1\r\nTransfer-Encoding: \r\n\r\n", "400 Bad Request"},
+		{"unsupported transfer coding", "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n", "501 Not Implemented"},
+		{"truncated", "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nabc", "400 Bad Request"},
+		{"unsupported version", "GET / HTTP/2.0\r\n\r\n", "505 HTTP Version Not Supported"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial(s.Addr().Network(), s.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			_, err = conn.Write([]byte(tc.raw))
+			require.NoError(t, err)
+			if tc.name == "truncated" {
+				conn.(*net.TCPConn).CloseWrite()
+			}
+			out, err := io.ReadAll(conn)
+			require.NoError(t, err)
+
+			reason := tc.status[4:]
+			assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 "+tc.status+"\r\n"), string(out))
+			assert.Contains(t, string(out), "Connection: close\r\n")
+			assert.True(t, strings.HasSuffix(string(out), "\r\n\r\n"+reason+"\n"))
+		})
+	}
+}
+
+func TestHTTP10(t *testing.T) {
+	s := startServer(t, echoTarget, Config{})
+
+	// Test: HTTP/1.0 closes by default
+	out := roundTrip(t, s, "GET /old HTTP/1.0\r\n\r\nGET /ignored HTTP/1.0\r\n\r\n")
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nContent-Length: 4\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n/old", out)
+
+	// Test: HTTP/1.0 keep-alive when asked for
+	out = roundTrip(t, s, "GET /one HTTP/1.0\r\nConnection: keep-alive\r\n\r\nGET /two HTTP/1.0\r\n\r\n")
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nContent-Length: 4\r\nContent-Type: text/plain\r\nConnection: keep-alive\r\n\r\n/one"+
+		"HTTP/1.0 200 OK\r\nContent-Length: 4\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n/two", out)
+
+	// Test: HTTP/1.0 with Transfer-Encoding closes even when asked to keep alive
+	out = roundTrip(t, s, "POST /chunked HTTP/1.0\r\nConnection: keep-alive\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"3\r\nabc\r\n0\r\n\r\nGET /smuggled HTTP/1.0\r\n\r\n")
+	assert.Equal(t, "HTTP/1.0 200 OK\r\nContent-Length: 8\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n/chunked", out)
+}